@@ -0,0 +1,185 @@
+package univedo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	contextType               = reflect.TypeOf((*context.Context)(nil)).Elem()
+	timeType                  = reflect.TypeOf(time.Time{})
+	remoteObjectReferenceType = reflect.TypeOf((*interface {
+		remoteObjectReference() (string, uint64)
+	})(nil)).Elem()
+)
+
+// Bind fills a struct of function fields with typed proxies for remote methods
+//
+// Go cannot implement interfaces at runtime, so proxies are described as a
+// struct instead:
+//
+//	var api struct {
+//		Ping     func(ctx context.Context, v string) (string, error)
+//		ApplyUTS func(uts string) error `univedo:"applyUts"`
+//	}
+//	err := univedo.Bind(session, &api)
+//
+// The remote method name is taken from the univedo struct tag or is the field
+// name with a lower case first letter. Every function must return an error as
+// its last result and may return one value before it, which is converted from
+// the remote result. An optional first context.Context argument aborts waiting
+// for the answer when it is done.
+func Bind(ro RemoteObject, proxy interface{}) error {
+	v := reflect.ValueOf(proxy)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.New("proxy must be a pointer to a struct")
+	}
+	v = v.Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if field.Type.Kind() != reflect.Func {
+			return fmt.Errorf("field %s is not a function", field.Name)
+		}
+		method := field.Tag.Get("univedo")
+		if method == "" {
+			method = lowerFirst(field.Name)
+		}
+		f, err := bindMethod(ro, method, field.Type)
+		if err != nil {
+			return fmt.Errorf("field %s: %s", field.Name, err.Error())
+		}
+		v.Field(i).Set(f)
+	}
+	return nil
+}
+
+// bindMethod creates a function of type t calling method on ro
+func bindMethod(ro RemoteObject, method string, t reflect.Type) (reflect.Value, error) {
+	if t.IsVariadic() {
+		return reflect.Value{}, errors.New("variadic functions are not supported")
+	}
+	if t.NumOut() < 1 || t.NumOut() > 2 || t.Out(t.NumOut()-1) != errorType {
+		return reflect.Value{}, errors.New("function must return an error as last result and at most one value")
+	}
+	withContext := t.NumIn() > 0 && t.In(0) == contextType
+
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		ctx := context.Background()
+		if withContext {
+			if c, ok := in[0].Interface().(context.Context); ok && c != nil {
+				ctx = c
+			}
+			in = in[1:]
+		}
+
+		args := make([]interface{}, len(in))
+		for i, v := range in {
+			arg, err := marshalValue(v)
+			if err != nil {
+				return bindResults(t, nil, fmt.Errorf("argument %d of %s: %s", i, method, err.Error()))
+			}
+			args[i] = arg
+		}
+
+		result, err := ro.CallROMContext(ctx, method, args...)
+		return bindResults(t, result, err)
+	}), nil
+}
+
+// bindResults converts the result of a remote call into the results of a bound function
+func bindResults(t reflect.Type, result interface{}, err error) []reflect.Value {
+	out := make([]reflect.Value, t.NumOut())
+	if t.NumOut() == 2 {
+		out[0] = reflect.Zero(t.Out(0))
+		if err == nil {
+			var v reflect.Value
+			v, err = convertValue(result, t.Out(0))
+			if err == nil {
+				out[0] = v
+			}
+		}
+	}
+	errValue := reflect.Zero(errorType)
+	if err != nil {
+		errValue = reflect.ValueOf(&err).Elem()
+	}
+	out[len(out)-1] = errValue
+	return out
+}
+
+// marshalValue converts a Go value into one that can be sent in the protocol
+// Named types are converted to their underlying kind and pointers are followed.
+// Structs other than time.Time cannot be sent.
+func marshalValue(v reflect.Value) (interface{}, error) {
+	if v.Type() == timeType || v.Type().Implements(remoteObjectReferenceType) {
+		return v.Interface(), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint(), nil
+	case reflect.Float32:
+		return float32(v.Float()), nil
+	case reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil, nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Bytes(), nil
+		}
+		fallthrough
+	case reflect.Array:
+		s := make([]interface{}, v.Len())
+		for i := range s {
+			e, err := marshalValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			s[i] = e
+		}
+		return s, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			e, err := marshalValue(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			m[iter.Key().String()] = e
+		}
+		return m, nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return marshalValue(v.Elem())
+	}
+	return nil, fmt.Errorf("cannot send %s", v.Type())
+}
+
+func lowerFirst(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(r)) + s[n:]
+}
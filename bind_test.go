@@ -0,0 +1,98 @@
+package univedo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testLevel int
+
+func TestBind(t *testing.T) {
+	Convey("bound proxies", t, func() {
+		s := new(testSession)
+		ro := NewBasicRO(23, s)
+
+		var api struct {
+			Ping     func(ctx context.Context, v []string) ([]string, error)
+			Count    func() (int, error)
+			ApplyUTS func(uts string) error `univedo:"applyUts"`
+			SetLevel func(level *testLevel, at time.Time) error
+			Move     func(to testPoint) error
+		}
+		err := Bind(ro, &api)
+		So(err, ShouldBeNil)
+
+		Convey("marshal arguments and convert results", func() {
+			s.onMessage = func() {
+				ro.receive([]interface{}{uint64(2), uint64(0), uint64(0), []interface{}{"a", "b"}})
+			}
+			res, err := api.Ping(context.Background(), []string{"a", "b"})
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, []string{"a", "b"})
+			So(s.msg, ShouldResemble, []interface{}{uint64(23), uint64(1), uint64(0), "ping", []interface{}{[]interface{}{"a", "b"}}})
+		})
+
+		Convey("convert numbers", func() {
+			s.onMessage = func() {
+				ro.receive([]interface{}{uint64(2), uint64(0), uint64(0), uint64(42)})
+			}
+			res, err := api.Count()
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, 42)
+		})
+
+		Convey("marshal named types and pointers", func() {
+			s.onMessage = func() {
+				ro.receive([]interface{}{uint64(2), s.msg[2], uint64(0), nil})
+			}
+			level := testLevel(3)
+			at := time.Date(2014, 1, 2, 3, 4, 5, 0, time.UTC)
+			So(api.SetLevel(&level, at), ShouldBeNil)
+			So(s.msg[4], ShouldResemble, []interface{}{int64(3), at})
+			So(api.SetLevel(nil, at), ShouldBeNil)
+			So(s.msg[4], ShouldResemble, []interface{}{nil, at})
+		})
+
+		Convey("reject arguments that cannot be sent", func() {
+			s.msg = nil
+			err := api.Move(testPoint{1, 2})
+			So(err, ShouldNotBeNil)
+			So(s.msg, ShouldBeNil)
+		})
+
+		Convey("use method names from tags", func() {
+			s.onMessage = func() {
+				ro.receive([]interface{}{uint64(2), uint64(0), uint64(1), "boom"})
+			}
+			err := api.ApplyUTS("<uts/>")
+			So(err, ShouldNotBeNil)
+			So(s.msg[3], ShouldEqual, "applyUts")
+		})
+
+		Convey("return conversion errors", func() {
+			s.onMessage = func() {
+				ro.receive([]interface{}{uint64(2), uint64(0), uint64(0), "many"})
+			}
+			_, err := api.Count()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("respect canceled contexts", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := api.Ping(ctx, nil)
+			So(err, ShouldEqual, context.Canceled)
+		})
+
+		Convey("reject invalid proxies", func() {
+			So(Bind(ro, api), ShouldNotBeNil)
+			var bad struct {
+				Ping func() string
+			}
+			So(Bind(ro, &bad), ShouldNotBeNil)
+		})
+	})
+}
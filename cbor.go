@@ -37,7 +37,7 @@ const (
 
 type message struct {
 	buffer   *bytes.Buffer
-	createRO func(id uint64, name string) interface{}
+	createRO func(id uint64, name string) (interface{}, error)
 }
 
 func (m *message) getLen(typeByte byte) (uint64, error) {
@@ -213,7 +213,7 @@ func (m *message) read() (interface{}, error) {
 				return nil, errors.New("expected int as ro id")
 			}
 
-			return m.createRO(id, name), nil
+			return m.createRO(id, name)
 
 		default:
 			return nil, errors.New("invalid tag in cbor protocol")
@@ -379,6 +379,13 @@ func (m *message) send(pObj interface{}) error {
 		m.sendTag(tagDateTime)
 		m.send(obj.Format(time.RFC3339Nano))
 
+	case interface {
+		remoteObjectReference() (string, uint64)
+	}:
+		name, id := obj.remoteObjectReference()
+		m.sendTag(tagRemoteObject)
+		return m.send([]interface{}{name, id})
+
 	default:
 		return errors.New("cannot send object in cbor protocol")
 	}
@@ -0,0 +1,121 @@
+package univedo
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// A columnType describes a column as announced by setColumnTypes
+type columnType struct {
+	// name is the univedo field type, e.g. integer or text
+	name string
+	// length is the size in bytes for integers and floats, the maximum length for others, 0 if unlimited
+	length uint64
+}
+
+var (
+	scanTypeInt8    = reflect.TypeOf(int8(0))
+	scanTypeInt16   = reflect.TypeOf(int16(0))
+	scanTypeInt32   = reflect.TypeOf(int32(0))
+	scanTypeInt64   = reflect.TypeOf(int64(0))
+	scanTypeUint64  = reflect.TypeOf(uint64(0))
+	scanTypeFloat32 = reflect.TypeOf(float32(0))
+	scanTypeFloat64 = reflect.TypeOf(float64(0))
+	scanTypeString  = reflect.TypeOf("")
+	scanTypeBytes   = reflect.TypeOf([]byte(nil))
+	scanTypeTime    = reflect.TypeOf(time.Time{})
+	scanTypeBool    = reflect.TypeOf(false)
+	scanTypeAny     = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// parseColumnTypes reads the argument of a setColumnTypes notification
+func parseColumnTypes(args []interface{}) ([]columnType, error) {
+	if len(args) != 1 {
+		return nil, errors.New("setColumnTypes without args")
+	}
+	typesI, ok := args[0].([]interface{})
+	if !ok {
+		return nil, errors.New("setColumnTypes without list")
+	}
+	types := make([]columnType, len(typesI))
+	for i, v := range typesI {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("setColumnTypes without maps")
+		}
+		name, ok := m["type"].(string)
+		if !ok {
+			return nil, errors.New("setColumnTypes without type name")
+		}
+		types[i].name = strings.ToLower(name)
+		if m["length"] != nil {
+			length, ok := m["length"].(uint64)
+			if !ok {
+				return nil, errors.New("setColumnTypes with invalid length")
+			}
+			types[i].length = length
+		}
+	}
+	return types, nil
+}
+
+// databaseTypeName returns the upper case univedo type name, e.g. INTEGER
+func (t columnType) databaseTypeName() string {
+	return strings.ToUpper(t.name)
+}
+
+// scanType returns the Go type values of the column are returned as
+// Integers and floats are sized by their length, the widest type if it is unknown.
+func (t columnType) scanType() reflect.Type {
+	switch t.name {
+	case "id", "foreign_key":
+		return scanTypeUint64
+	case "integer":
+		switch t.length {
+		case 1:
+			return scanTypeInt8
+		case 2:
+			return scanTypeInt16
+		case 4:
+			return scanTypeInt32
+		}
+		return scanTypeInt64
+	case "float":
+		if t.length == 4 {
+			return scanTypeFloat32
+		}
+		return scanTypeFloat64
+	case "char", "text", "uuid":
+		return scanTypeString
+	case "blob":
+		return scanTypeBytes
+	case "datetime":
+		return scanTypeTime
+	case "bool":
+		return scanTypeBool
+	}
+	return scanTypeAny
+}
+
+// variableLength returns the maximum length of char, text and blob columns
+func (t columnType) variableLength() (int64, bool) {
+	switch t.name {
+	case "char", "text", "blob":
+		if t.length == 0 || t.length > math.MaxInt64 {
+			return math.MaxInt64, true
+		}
+		return int64(t.length), true
+	}
+	return 0, false
+}
+
+// nullable reports whether the column may hold NULL, only ids are known not to
+func (t columnType) nullable() (nullable, ok bool) {
+	if t.name == "id" {
+		return false, true
+	}
+	return false, false
+}
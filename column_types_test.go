@@ -0,0 +1,73 @@
+package univedo
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestColumnTypes(t *testing.T) {
+	Convey("column types", t, func() {
+		Convey("are parsed", func() {
+			types, err := parseColumnTypes([]interface{}{[]interface{}{
+				map[string]interface{}{"type": "integer", "length": uint64(8)},
+				map[string]interface{}{"type": "Text"},
+			}})
+			So(err, ShouldBeNil)
+			So(types, ShouldResemble, []columnType{{name: "integer", length: 8}, {name: "text"}})
+		})
+
+		Convey("reject invalid notifications", func() {
+			_, err := parseColumnTypes(nil)
+			So(err, ShouldNotBeNil)
+			_, err = parseColumnTypes([]interface{}{"integer"})
+			So(err, ShouldNotBeNil)
+			_, err = parseColumnTypes([]interface{}{[]interface{}{map[string]interface{}{"length": uint64(8)}}})
+			So(err, ShouldNotBeNil)
+			_, err = parseColumnTypes([]interface{}{[]interface{}{map[string]interface{}{"type": "char", "length": "8"}}})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("map to scan types", func() {
+			for t, scanType := range map[columnType]reflect.Type{
+				{name: "id"}:                 scanTypeUint64,
+				{name: "foreign_key"}:        scanTypeUint64,
+				{name: "integer", length: 1}: scanTypeInt8,
+				{name: "integer", length: 2}: scanTypeInt16,
+				{name: "integer", length: 4}: scanTypeInt32,
+				{name: "integer", length: 8}: scanTypeInt64,
+				{name: "integer"}:            scanTypeInt64,
+				{name: "float", length: 4}:   scanTypeFloat32,
+				{name: "float", length: 8}:   scanTypeFloat64,
+				{name: "float"}:              scanTypeFloat64,
+				{name: "text", length: 4}:    scanTypeString,
+			} {
+				So(t.scanType(), ShouldEqual, scanType)
+			}
+		})
+
+		Convey("fall back for unknown types", func() {
+			t := columnType{name: "geometry"}
+			So(t.databaseTypeName(), ShouldEqual, "GEOMETRY")
+			So(t.scanType(), ShouldEqual, scanTypeAny)
+			_, ok := t.variableLength()
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("are unknown after invalid notifications", func() {
+			s := newStatement(1, &testSession{onMessage: func() {}}).(*stmt)
+			So(s.receive([]interface{}{uint64(romNotify), "setColumnTypes", []interface{}{"integer"}}), ShouldBeNil)
+			<-s.delivered()
+			So(s.columnTypes, ShouldBeNil)
+
+			r := &result{cols: []string{"id"}}
+			So(r.ColumnTypeDatabaseTypeName(0), ShouldEqual, "")
+			So(r.ColumnTypeScanType(0), ShouldEqual, scanTypeAny)
+			_, ok := r.ColumnTypeLength(0)
+			So(ok, ShouldBeFalse)
+			_, ok = r.ColumnTypeNullable(-1)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
@@ -2,28 +2,65 @@ package univedo
 
 import (
 	"bytes"
+	"context"
 	"errors"
-	"strings"
-
-	"code.google.com/p/go.net/websocket"
-	// TODO remove
-	_ "fmt"
+	"fmt"
 	"net/url"
+	"sort"
+	"sync"
+	"time"
 )
 
-// registeredRemoteObjects is a map from RO name to factory function
-var registeredRemoteObjects = make(map[string]func(id uint64, s sender) RemoteObject)
+// ErrConnectionLost is returned by calls made while the connection is down
+var ErrConnectionLost = errors.New("univedo: connection lost")
 
-// RegisterRemoteObject adds a remote object factory for a RO name
-func RegisterRemoteObject(name string, factory func(id uint64, session sender) RemoteObject) {
-	registeredRemoteObjects[name] = factory
-}
+// ErrConnectionClosed is returned by calls on a closed connection
+var ErrConnectionClosed = errors.New("univedo: connection closed")
+
+// ErrRemoteObjectReleased is returned by calls on remote objects that were deleted
+var ErrRemoteObjectReleased = errors.New("univedo: remote object was released")
 
 // A Connection with an univedo server
 type Connection struct {
-	ws            *websocket.Conn
-	urologin      RemoteObject
-	remoteObjects map[uint64]RemoteObject
+	intercept Interceptor
+	reconnect *ReconnectPolicy
+	wrap      []func(Transport) Transport
+	trace     *tracer
+	registry  *Registry
+	sendQueue int
+	metrics   *metrics
+
+	// closing is closed by Close, done once the connection is down for good
+	closing   chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+
+	// mutex guards the fields below
+	mutex        sync.Mutex
+	transport    Transport
+	writer       *frameWriter
+	urologin     RemoteObject
+	sessions     []*Session
+	closed       bool
+	shuttingDown bool
+	finished     bool
+	err          error
+	onDisconnect []func(error)
+	onReconnect  []func()
+	onClose      []func(error)
+	dropErr      error
+	lastRTT      time.Duration
+	negotiated   bool
+	version      string
+	capabilities []string
+	// versions are offered during negotiation, they are set once by newConnection
+	versions []string
+	// credentialsExpiredCode is the error code renewing the credentials of sessions
+	credentialsExpiredCode string
+
+	// remoteObjectsMutex guards remoteObjects
+	remoteObjectsMutex sync.Mutex
+	remoteObjects      map[uint64]RemoteObject
 }
 
 // originForURL returns an origin matching the given URL
@@ -37,47 +74,389 @@ func originForURL(s string) (string, error) {
 }
 
 // Dial opens a new connection with an univedo server
-func Dial(url string) (*Connection, error) {
-	// Extract the origin from the URL
-	origin, err := originForURL(url)
+func Dial(url string, opts ...DialOption) (*Connection, error) {
+	cfg := newDialConfig(opts)
+	dial, err := websocketDialer(url, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	// Dial the websocket
-	if !strings.HasSuffix(url, "/") {
-		url += "/"
+	t, err := dial()
+	if err != nil {
+		return nil, err
 	}
 
-	url += "v1"
-	ws, err := websocket.Dial(url, "", origin)
+	defaultReconnectDial := cfg.reconnect != nil && cfg.reconnect.Dial == nil
+	if defaultReconnectDial {
+		cfg.reconnect.Dial = dial
+	}
+	c := newConnection(t, cfg)
+	if len(cfg.protocolVersions) == 0 {
+		return c, nil
+	}
+	version, err := c.negotiateOnDial(cfg)
 	if err != nil {
 		return nil, err
 	}
+	if version == cfg.protocolPath {
+		return c, nil
+	}
+
+	// The server picked another version, connect again at its path
+	c.Close()
+	cfg.protocolPath = version
+	if dial, err = websocketDialer(url, cfg); err != nil {
+		return nil, err
+	}
+	if t, err = dial(); err != nil {
+		return nil, err
+	}
+	if defaultReconnectDial {
+		cfg.reconnect.Dial = dial
+	}
+	c = newConnection(t, cfg)
+	if _, err := c.negotiateOnDial(cfg); err != nil {
+		return nil, err
+	}
+	if c.ProtocolVersion() != version {
+		c.Close()
+		return nil, fmt.Errorf("univedo: server switched protocol version from %q to %q", version, c.ProtocolVersion())
+	}
+	return c, nil
+}
+
+// negotiateOnDial negotiates the protocol of a new connection, closing it on failure
+func (c *Connection) negotiateOnDial(cfg *dialConfig) (string, error) {
+	ctx, cancel := negotiateContext(cfg.handshakeTimeout)
+	defer cancel()
+	if err := c.Negotiate(ctx); err != nil {
+		c.Close()
+		return "", err
+	}
+	return c.ProtocolVersion(), nil
+}
 
-	c := &Connection{ws: ws, remoteObjects: make(map[uint64]RemoteObject)}
-	go func() {
-		// TODO error handling
-		err := c.handleWebsocket()
-		/*		fmt.Printf("%s\n", err.Error())*/
-		_ = err
-	}()
+// NewConnection starts a connection on an established transport
+func NewConnection(t Transport, opts ...DialOption) *Connection {
+	return newConnection(t, newDialConfig(opts))
+}
 
-	// Login
-	c.urologin = NewBasicRO(0, c)
-	c.remoteObjects[0] = c.urologin
+func newConnection(t Transport, cfg *dialConfig) *Connection {
+	c := &Connection{
+		intercept:     chainInterceptors(cfg.interceptors),
+		reconnect:     cfg.reconnect,
+		wrap:          cfg.wrapTransport,
+		trace:         newTracer(cfg.logger, cfg.redactors),
+		registry:      cfg.registry,
+		sendQueue:     cfg.sendQueue,
+		closing:       make(chan struct{}),
+		done:          make(chan struct{}),
+		remoteObjects: make(map[uint64]RemoteObject),
+		versions:      cfg.protocolVersions,
 
-	return c, nil
+		credentialsExpiredCode: cfg.credentialsExpiredCode,
+	}
+	if !cfg.disableMetrics {
+		c.metrics = newMetrics(c)
+	}
+	t = c.attach(t)
+	go c.run(t)
+	if cfg.heartbeatInterval > 0 {
+		maxMissed := cfg.heartbeatMaxMissed
+		if maxMissed < 1 {
+			maxMissed = 1
+		}
+		go c.heartbeat(cfg.heartbeatInterval, maxMissed)
+	}
+	return c
+}
+
+// attach makes t the transport of the connection and logs in again
+// It returns t wrapped by the transport wrappers of the connection.
+func (c *Connection) attach(t Transport) Transport {
+	for _, wrap := range c.wrap {
+		t = wrap(t)
+	}
+	urologin := NewBasicRO(0, c)
+
+	c.remoteObjectsMutex.Lock()
+	c.remoteObjects[0] = urologin
+	c.remoteObjectsMutex.Unlock()
+
+	w := newFrameWriter(t, c.sendQueue)
+
+	c.mutex.Lock()
+	c.transport = t
+	c.writer = w
+	c.urologin = urologin
+	closed := c.closed
+	c.mutex.Unlock()
+
+	// Close may have been called while reconnecting
+	if closed {
+		t.Close()
+	}
+	return t
+}
+
+// run reads frames from t until the connection is lost and then reconnects if configured
+func (c *Connection) run(t Transport) {
+	err := c.handleFrames(t)
+	t.Close()
+
+	c.mutex.Lock()
+	closed := c.closed
+	w := c.writer
+	c.transport = nil
+	c.writer = nil
+	if c.dropErr != nil {
+		err = c.dropErr
+		c.dropErr = nil
+	}
+	c.mutex.Unlock()
+	// The transport is closed, so queued frames fail quickly
+	w.close(flushTimeout)
+	c.metrics.lost()
+
+	if closed {
+		c.finish(ErrConnectionClosed)
+		return
+	}
+	if c.reconnect == nil {
+		c.finish(err)
+		return
+	}
+	c.reconnectAfter(err)
+}
+
+// drop closes the transport t if it is still in use, reporting err as the reason
+func (c *Connection) drop(t Transport, err error) {
+	c.mutex.Lock()
+	if c.transport != t {
+		c.mutex.Unlock()
+		return
+	}
+	c.dropErr = err
+	c.mutex.Unlock()
+	t.Close()
+}
+
+// finish shuts the connection down for good and fails all remote objects with err
+func (c *Connection) finish(err error) {
+	c.mutex.Lock()
+	if c.finished {
+		c.mutex.Unlock()
+		return
+	}
+	c.closed = true
+	c.finished = true
+	c.err = err
+	callbacks := c.onClose
+	c.mutex.Unlock()
+
+	c.invalidateRemoteObjects(err)
+	c.metrics.unpublish()
+	close(c.done)
+	for _, f := range callbacks {
+		f(err)
+	}
+}
+
+// invalidateRemoteObjects fails all calls on remote objects of the connection with err
+func (c *Connection) invalidateRemoteObjects(err error) {
+	c.remoteObjectsMutex.Lock()
+	objects := make([]RemoteObject, 0, len(c.remoteObjects))
+	for _, ro := range c.remoteObjects {
+		objects = append(objects, ro)
+	}
+	c.remoteObjectsMutex.Unlock()
+
+	for _, ro := range objects {
+		if i, ok := ro.(interface {
+			invalidate(error)
+		}); ok {
+			i.invalidate(err)
+		}
+	}
 }
 
 // Close the connection
-func (c *Connection) Close() {
-	c.ws.Close()
+// Frames already queued for sending are written before the transport is closed.
+// Close returns the error closing the transport, later calls return nil.
+func (c *Connection) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.mutex.Lock()
+		c.closed = true
+		t, w := c.transport, c.writer
+		c.mutex.Unlock()
+
+		close(c.closing)
+		if w != nil {
+			w.close(flushTimeout)
+		}
+		if t != nil {
+			err = t.Close()
+		}
+	})
+	return err
+}
+
+// Shutdown gracefully closes the connection
+// New calls fail with ErrConnectionClosed while Shutdown waits for the answers to
+// calls in flight. It then releases all remote objects on the server and closes
+// the connection. If ctx is done first, the connection is closed right away and
+// ctx.Err() is returned.
+func (c *Connection) Shutdown(ctx context.Context) error {
+	c.mutex.Lock()
+	c.shuttingDown = true
+	c.mutex.Unlock()
+
+	err := c.waitForCalls(ctx)
+	if err == nil {
+		err = c.releaseRemoteObjects(ctx)
+	}
+	if closeErr := c.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitForCalls waits until all calls in flight are answered or ctx is done
+func (c *Connection) waitForCalls(ctx context.Context) error {
+	c.remoteObjectsMutex.Lock()
+	var pending []*Future
+	for _, ro := range c.remoteObjects {
+		if p, ok := ro.(interface {
+			pendingCalls() []*Future
+		}); ok {
+			pending = append(pending, p.pendingCalls()...)
+		}
+	}
+	c.remoteObjectsMutex.Unlock()
+
+	for _, f := range pending {
+		select {
+		case <-f.Done():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// releaseRemoteObjects sends delete messages for all remote objects received from the server
+func (c *Connection) releaseRemoteObjects(ctx context.Context) error {
+	c.remoteObjectsMutex.Lock()
+	var ids []uint64
+	for id, ro := range c.remoteObjects {
+		// The login object always exists and exported objects live on the client
+		if b, ok := ro.(*BasicRemoteObject); id == 0 || ok && b.handler != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	c.remoteObjectsMutex.Unlock()
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		if err := c.sendMessageContext(ctx, id, uint64(romDelete)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Done returns a channel that is closed when the connection is down for good
+// This happens after Close, or when the connection is lost and not reconnected.
+func (c *Connection) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection is down, or nil while it is alive
+// It is ErrConnectionClosed after Close and a *ReconnectError if reconnecting failed.
+func (c *Connection) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.finished {
+		return nil
+	}
+	return c.err
+}
+
+// OnClose registers a callback run with the error from Err when the connection is down for good
+// If the connection is down already, f is called immediately.
+func (c *Connection) OnClose(f func(error)) {
+	c.mutex.Lock()
+	if c.finished {
+		err := c.err
+		c.mutex.Unlock()
+		f(err)
+		return
+	}
+	c.onClose = append(c.onClose, f)
+	c.mutex.Unlock()
+}
+
+// OnDisconnect registers a callback run when the connection is lost and a reconnect starts
+func (c *Connection) OnDisconnect(f func(error)) {
+	c.mutex.Lock()
+	c.onDisconnect = append(c.onDisconnect, f)
+	c.mutex.Unlock()
+}
+
+// OnReconnect registers a callback run after the connection and its sessions were restored
+func (c *Connection) OnReconnect(f func()) {
+	c.mutex.Lock()
+	c.onReconnect = append(c.onReconnect, f)
+	c.mutex.Unlock()
 }
 
 // GetSession connects to a bucket with credentials
 func (c *Connection) GetSession(bucket string, creds map[string]interface{}) (*Session, error) {
-	iSession, err := c.urologin.CallROM("getSession", bucket, creds)
+	return c.GetSessionWithProvider(context.Background(), bucket, StaticCredentials(creds))
+}
+
+// GetSessionWithProvider connects to a bucket with credentials from provider
+// The provider is asked again whenever the session has to be reopened, i.e. after
+// a reconnect or when the server rejects the credentials as expired.
+func (c *Connection) GetSessionWithProvider(ctx context.Context, bucket string, provider CredentialsProvider) (*Session, error) {
+	creds, err := provider.Credentials(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	session, err := c.getSession(bucket, creds)
+	if err != nil {
+		return nil, err
+	}
+	session.bucket = bucket
+	session.provider = provider
+	session.setOwner(session)
+
+	c.mutex.Lock()
+	c.sessions = append(c.sessions, session)
+	c.mutex.Unlock()
+	return session, nil
+}
+
+func (c *Connection) getSession(bucket string, creds map[string]interface{}) (*Session, error) {
+	c.mutex.Lock()
+	urologin, err := c.urologin, c.err
+	c.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	iSession, err := urologin.CallROM("getSession", bucket, creds)
 	if err != nil {
 		return nil, err
 	}
@@ -88,19 +467,50 @@ func (c *Connection) GetSession(bucket string, creds map[string]interface{}) (*S
 	return session, nil
 }
 
+// interceptor returns the chained interceptors of the connection
+func (c *Connection) interceptor() Interceptor {
+	return c.intercept
+}
+
 func (c *Connection) sendMessage(data ...interface{}) error {
+	return c.sendMessageContext(context.Background(), data...)
+}
+
+// sendMessageContext queues a message for the writer, giving up on waiting for room when ctx is done
+func (c *Connection) sendMessageContext(ctx context.Context, data ...interface{}) error {
 	m := &message{buffer: &bytes.Buffer{}}
 	for _, v := range data {
-		m.send(v)
+		// A partly encoded frame must never reach the server
+		if err := m.send(v); err != nil {
+			return err
+		}
+	}
+	roID, _ := data[0].(uint64)
+	c.trace.trace(false, roID, data[1:])
+
+	c.mutex.Lock()
+	w, err, shuttingDown := c.writer, c.err, c.shuttingDown
+	c.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if shuttingDown && data[1] == romCall {
+		return ErrConnectionClosed
 	}
-	return websocket.Message.Send(c.ws, m.buffer.Bytes())
+	if w == nil {
+		return ErrConnectionLost
+	}
+
+	c.metrics.sending(roID, data[1:])
+	frame := m.buffer.Bytes()
+	err = w.write(ctx, frame)
+	c.metrics.sent(roID, data[1:], len(frame), err)
+	return err
 }
 
-func (c *Connection) handleWebsocket() error {
+func (c *Connection) handleFrames(t Transport) error {
 	for {
-		var buffer []byte
-		err := websocket.Message.Receive(c.ws, &buffer)
-
+		buffer, err := t.ReceiveFrame()
 		if err != nil {
 			return err
 		}
@@ -116,11 +526,6 @@ func (c *Connection) handleWebsocket() error {
 			return errors.New("ro id should be int")
 		}
 
-		ro := c.remoteObjects[roID]
-		if ro == nil {
-			return errors.New("ro not known")
-		}
-
 		var data []interface{}
 		for !msg.empty() {
 			v, err := msg.read()
@@ -129,6 +534,17 @@ func (c *Connection) handleWebsocket() error {
 			}
 			data = append(data, v)
 		}
+		c.trace.trace(true, roID, data)
+		c.metrics.received(roID, data, len(buffer))
+
+		ro := c.remoteObject(roID)
+		if ro == nil {
+			return errors.New("ro not known")
+		}
+		if len(data) > 0 && data[0] == uint64(romDelete) {
+			c.forgetRemoteObject(ro)
+			continue
+		}
 
 		err = ro.receive(data)
 		if err != nil {
@@ -137,14 +553,107 @@ func (c *Connection) handleWebsocket() error {
 	}
 }
 
-func (c *Connection) receiveRO(id uint64, name string) interface{} {
+func (c *Connection) receiveRO(id uint64, name string) (interface{}, error) {
+	// Exported objects must not be replaced by objects of the server
+	if exported, ok := c.remoteObject(id).(*BasicRemoteObject); ok && exported.handler != nil {
+		return nil, fmt.Errorf("univedo: server sent remote object %d, which is the id of an exported object", id)
+	}
+
 	var ro RemoteObject
-	factory := registeredRemoteObjects[name]
+	factory := c.registry.factory(name)
 	if factory != nil {
 		ro = factory(id, c)
 	} else {
 		ro = NewBasicRO(id, c)
 	}
+	if named, ok := ro.(interface {
+		setName(string)
+	}); ok {
+		named.setName(name)
+	}
+	c.remoteObjectsMutex.Lock()
 	c.remoteObjects[id] = ro
-	return ro
+	c.remoteObjectsMutex.Unlock()
+	return ro, nil
+}
+
+// releaseRemoteObject deletes ro on the server and forgets it
+func (c *Connection) releaseRemoteObject(ro RemoteObject) error {
+	err := c.sendMessage(ro.ID(), uint64(romDelete))
+	c.forgetRemoteObject(ro)
+	return err
+}
+
+// forgetRemoteObject drops ro, which was deleted, and stops restoring it after reconnects
+// Calls on ro fail with ErrRemoteObjectReleased from now on.
+func (c *Connection) forgetRemoteObject(ro RemoteObject) {
+	id := ro.ID()
+	c.remoteObjectsMutex.Lock()
+	if c.remoteObjects[id] == ro {
+		delete(c.remoteObjects, id)
+	}
+	c.remoteObjectsMutex.Unlock()
+
+	c.mutex.Lock()
+	sessions := c.sessions[:0:0]
+	for _, s := range c.sessions {
+		if RemoteObject(s) != ro {
+			sessions = append(sessions, s)
+		}
+	}
+	c.sessions = sessions
+	c.mutex.Unlock()
+
+	for _, s := range sessions {
+		s.forgetPerspective(ro)
+	}
+	if i, ok := ro.(interface {
+		invalidate(error)
+	}); ok {
+		i.invalidate(ErrRemoteObjectReleased)
+	}
+}
+
+func (c *Connection) remoteObject(id uint64) RemoteObject {
+	c.remoteObjectsMutex.Lock()
+	defer c.remoteObjectsMutex.Unlock()
+	return c.remoteObjects[id]
+}
+
+// Export makes a Go object callable by the server under the given id and name
+// obj is either a CallHandler or any value whose exported methods are called by
+// name, e.g. a remote call to "ping" invokes the Ping method. The returned remote
+// object can be passed as an argument to remote calls to hand it to the server.
+// The id must not be in use, and if the server sends a remote object with the id
+// of an exported object later on, the connection fails rather than replacing it.
+func (c *Connection) Export(id uint64, name string, obj interface{}) (*BasicRemoteObject, error) {
+	handler, ok := obj.(CallHandler)
+	if !ok {
+		h, err := newReflectHandler(obj)
+		if err != nil {
+			return nil, err
+		}
+		handler = h
+	}
+
+	ro := NewBasicRO(id, c)
+	ro.name = name
+	ro.handler = handler
+
+	c.remoteObjectsMutex.Lock()
+	defer c.remoteObjectsMutex.Unlock()
+	if c.remoteObjects[id] != nil {
+		return nil, errors.New("remote object id already in use")
+	}
+	c.remoteObjects[id] = ro
+	return ro, nil
+}
+
+// Unexport removes an object previously exported with Export
+func (c *Connection) Unexport(id uint64) {
+	c.remoteObjectsMutex.Lock()
+	defer c.remoteObjectsMutex.Unlock()
+	if ro, ok := c.remoteObjects[id].(*BasicRemoteObject); ok && ro.handler != nil {
+		delete(c.remoteObjects, id)
+	}
 }
@@ -1,14 +1,27 @@
 package univedo
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"code.google.com/p/go.net/websocket"
+	"github.com/univedo/univedo-go/univedotest"
+
 	. "github.com/smartystreets/goconvey/convey"
 )
 
-const testURL = "ws://localhost:9011/"
+// testServer is the fake univedo server the tests run against
+var testServer = univedotest.NewServer()
+
+var testURL = testServer.URL
 
 func pingTest(v interface{}) {
 	connection, err := Dial(testURL)
@@ -88,3 +101,192 @@ func TestSession(t *testing.T) {
 		})
 	})
 }
+
+func TestConnectionHealth(t *testing.T) {
+	Convey("connection health", t, func() {
+		Convey("is alive until closed", func() {
+			client, server := Pipe()
+			go serveTestPeer(server, func(uint64, string, []interface{}) interface{} { return nil })
+			connection := NewConnection(client)
+			closed := make(chan error, 1)
+			connection.OnClose(func(err error) { closed <- err })
+			So(connection.Err(), ShouldBeNil)
+			select {
+			case <-connection.Done():
+				t.Fatal("connection done before Close")
+			default:
+			}
+
+			connection.Close()
+			<-connection.Done()
+			So(connection.Err(), ShouldEqual, ErrConnectionClosed)
+			So(<-closed, ShouldEqual, ErrConnectionClosed)
+		})
+
+		Convey("reports lost connections", func() {
+			client, server := Pipe()
+			connection := NewConnection(client)
+			server.Close()
+			<-connection.Done()
+			So(connection.Err(), ShouldNotBeNil)
+			So(connection.Err(), ShouldNotEqual, ErrConnectionClosed)
+			_, err := connection.GetSession("bucket", nil)
+			So(err, ShouldEqual, connection.Err())
+
+			var err2 error
+			connection.OnClose(func(err error) { err2 = err })
+			So(err2, ShouldEqual, connection.Err())
+		})
+	})
+}
+
+func TestDialOptions(t *testing.T) {
+	Convey("dial options", t, func() {
+		requests := make(chan *http.Request, 1)
+		server := httptest.NewServer(websocket.Server{
+			Handshake: func(config *websocket.Config, req *http.Request) error {
+				requests <- req
+				return nil
+			},
+			Handler: func(ws *websocket.Conn) {
+				ws.Read(make([]byte, 1))
+			},
+		})
+		defer server.Close()
+		wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+		Convey("default to the v1 path and the host as origin", func() {
+			connection, err := Dial(wsURL)
+			So(err, ShouldBeNil)
+			req := <-requests
+			So(req.URL.Path, ShouldEqual, "/v1")
+			So(req.Header.Get("Origin"), ShouldEqual, "http://"+strings.TrimPrefix(server.URL, "http://"))
+			connection.Close()
+		})
+
+		Convey("set headers, origin and protocol path", func() {
+			connection, err := Dial(wsURL+"/bucket",
+				WithHeader("Authorization", "Bearer foo"),
+				WithOrigin("https://example.com"),
+				WithProtocolPath("v2"),
+				WithDialTimeout(time.Second),
+				WithHandshakeTimeout(time.Second))
+			So(err, ShouldBeNil)
+			req := <-requests
+			So(req.URL.Path, ShouldEqual, "/bucket/v2")
+			So(req.Header.Get("Authorization"), ShouldEqual, "Bearer foo")
+			So(req.Header.Get("Origin"), ShouldEqual, "https://example.com")
+			connection.Close()
+		})
+
+		Convey("use TLS configs for wss", func() {
+			tlsServer := httptest.NewTLSServer(server.Config.Handler)
+			defer tlsServer.Close()
+			pool := x509.NewCertPool()
+			pool.AddCert(tlsServer.Certificate())
+			wssURL := "wss" + strings.TrimPrefix(tlsServer.URL, "https")
+
+			_, err := Dial(wssURL)
+			So(err, ShouldNotBeNil)
+
+			connection, err := Dial(wssURL, WithTLSConfig(&tls.Config{RootCAs: pool}))
+			So(err, ShouldBeNil)
+			<-requests
+			connection.Close()
+		})
+
+		Convey("reject unknown schemes", func() {
+			_, err := Dial("http://localhost/")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestShutdown(t *testing.T) {
+	Convey("shutdown", t, func() {
+		client, server := Pipe()
+		release := make(chan struct{})
+		deleted := make(chan uint64, 10)
+		go func() {
+			for {
+				frame, err := server.ReceiveFrame()
+				if err != nil {
+					return
+				}
+				msg := &message{buffer: bytes.NewBuffer(frame)}
+				var data []interface{}
+				for !msg.empty() {
+					v, _ := msg.read()
+					data = append(data, v)
+				}
+				if data[1] == uint64(romDelete) {
+					deleted <- data[0].(uint64)
+					continue
+				}
+				var result interface{} = testRORef{"com.univedo.session", 1}
+				if data[3] == "slow" {
+					go func() {
+						<-release
+						reply := &message{buffer: &bytes.Buffer{}}
+						for _, v := range []interface{}{data[0], uint64(romAnswer), data[2], uint64(0), "done"} {
+							reply.send(v)
+						}
+						server.SendFrame(reply.buffer.Bytes())
+					}()
+					continue
+				}
+				reply := &message{buffer: &bytes.Buffer{}}
+				for _, v := range []interface{}{data[0], uint64(romAnswer), data[2], uint64(0), result} {
+					reply.send(v)
+				}
+				server.SendFrame(reply.buffer.Bytes())
+			}
+		}()
+
+		connection := NewConnection(client)
+		session, err := connection.GetSession("bucket", nil)
+		So(err, ShouldBeNil)
+		slow := session.CallROMAsync("slow")
+
+		Convey("waits for calls in flight and releases remote objects", func() {
+			shutdown := make(chan error, 1)
+			go func() { shutdown <- connection.Shutdown(context.Background()) }()
+
+			for shuttingDown := false; !shuttingDown; {
+				connection.mutex.Lock()
+				shuttingDown = connection.shuttingDown
+				connection.mutex.Unlock()
+			}
+			_, err := session.CallROM("ping")
+			So(err, ShouldEqual, ErrConnectionClosed)
+			select {
+			case <-shutdown:
+				t.Fatal("shutdown before the call in flight was answered")
+			default:
+			}
+
+			close(release)
+			So(<-shutdown, ShouldBeNil)
+			result, err := slow.Wait()
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, "done")
+			So(<-deleted, ShouldEqual, 1)
+			So(connection.Err(), ShouldEqual, ErrConnectionClosed)
+		})
+
+		Convey("gives up when the context is done", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			So(connection.Shutdown(ctx), ShouldEqual, context.DeadlineExceeded)
+			<-connection.Done()
+			_, err := slow.Wait()
+			So(err, ShouldEqual, ErrConnectionClosed)
+			So(len(deleted), ShouldEqual, 0)
+		})
+
+		Convey("close returns nil when called again", func() {
+			So(connection.Close(), ShouldBeNil)
+			So(connection.Close(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,78 @@
+package univedo
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+// connector opens connections of the SQL driver from a Config
+type connector struct {
+	cfg    Config
+	driver driver.Driver
+}
+
+// NewConnector returns a connector for sql.OpenDB opening connections as configured
+// Unlike with a DSN, credentials can come from a CredentialsProvider and the
+// connections can share one Connection.
+func NewConnector(cfg Config) driver.Connector {
+	return &connector{cfg: cfg, driver: UnivedoDriver{}}
+}
+
+// OpenConnector parses the DSN name once for all connections as required by database/sql
+func (d UnivedoDriver) OpenConnector(name string) (driver.Connector, error) {
+	cfg, err := ParseDSN(name)
+	if err != nil {
+		return nil, err
+	}
+	if d.Credentials != nil {
+		cfg.CredentialsProvider = d.Credentials
+	}
+	cfg.DialOptions = d.DialOptions
+	return &connector{cfg: *cfg, driver: d}, nil
+}
+
+// Connect opens a connection as required by database/sql
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	connection, shared := c.cfg.Connection, true
+	if connection == nil {
+		var err error
+		connection, err = Dial(c.cfg.serverURL(), c.cfg.dialOptions()...)
+		if err != nil {
+			return nil, err
+		}
+		shared = false
+	}
+
+	conn, err := openConn(ctx, connection, &c.cfg)
+	if err != nil {
+		if !shared {
+			connection.Close()
+		}
+		return nil, err
+	}
+	conn.shared = shared
+	return conn, nil
+}
+
+// Driver returns the driver of the connector as required by database/sql
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// openConn opens the session and perspective of cfg on connection
+func openConn(ctx context.Context, connection *Connection, cfg *Config) (*Conn, error) {
+	session, err := connection.GetSessionWithProvider(ctx, cfg.Bucket, cfg.credentials())
+	if err != nil {
+		return nil, err
+	}
+	perspective, err := session.GetPerspective(cfg.Perspective)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return &Conn{Connection: connection, session: session, perspective: perspective}, nil
+}
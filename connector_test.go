@@ -0,0 +1,91 @@
+package univedo
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/univedo/univedo-go/univedotest"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConnector(t *testing.T) {
+	Convey("connectors", t, func() {
+		server := univedotest.NewServer()
+		defer server.Close()
+		uts, err := ioutil.ReadFile("test.uts")
+		So(err, ShouldBeNil)
+		setup, err := Dial(server.URL)
+		So(err, ShouldBeNil)
+		session, err := setup.GetSession("bucket", nil)
+		So(err, ShouldBeNil)
+		So(session.ApplyUTS(string(uts)), ShouldBeNil)
+		setup.Close()
+		So(server.Exec("bucket", "insert into fields_inclusive (table_id) values (?)", 7), ShouldBeNil)
+
+		u, err := url.Parse(server.URL)
+		So(err, ShouldBeNil)
+		var requests int32
+		cfg := Config{
+			Host:        u.Host,
+			Bucket:      "bucket",
+			Perspective: "cefb4ed2-4ce3-4825-8550-b68a3c142f0a",
+			CredentialsProvider: CredentialsFunc(func(context.Context, string) (map[string]interface{}, error) {
+				atomic.AddInt32(&requests, 1)
+				return map[string]interface{}{"username": "marvin"}, nil
+			}),
+		}
+
+		Convey("open connections from a config", func() {
+			db := sql.OpenDB(NewConnector(cfg))
+			defer db.Close()
+			var count int
+			So(db.QueryRow("select count(*) from fields_inclusive where table_id = 7").Scan(&count), ShouldBeNil)
+			So(count, ShouldEqual, 1)
+			So(atomic.LoadInt32(&requests), ShouldBeGreaterThan, 0)
+		})
+
+		Convey("share a connection", func() {
+			connection, err := Dial(server.URL)
+			So(err, ShouldBeNil)
+			defer connection.Close()
+			cfg.Connection = connection
+
+			db := sql.OpenDB(NewConnector(cfg))
+			So(db.Ping(), ShouldBeNil)
+			So(connection.sessions, ShouldHaveLength, 1)
+			So(db.Close(), ShouldBeNil)
+			So(connection.Err(), ShouldBeNil)
+			So(connection.sessions, ShouldBeEmpty)
+			_, err = connection.GetSession("bucket", nil)
+			So(err, ShouldBeNil)
+
+			cfg.Perspective = "unknown"
+			_, err = NewConnector(cfg).Connect(context.Background())
+			So(err, ShouldNotBeNil)
+			So(connection.sessions, ShouldHaveLength, 1)
+		})
+
+		Convey("are opened by the driver for DSNs", func() {
+			c, err := UnivedoDriver{}.OpenConnector(cfg.FormatDSN())
+			So(err, ShouldBeNil)
+			db := sql.OpenDB(c)
+			defer db.Close()
+			So(db.Ping(), ShouldBeNil)
+
+			_, err = UnivedoDriver{}.OpenConnector("http://localhost/b/p")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("respect the context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := NewConnector(cfg).Connect(ctx)
+			So(err, ShouldEqual, context.Canceled)
+		})
+	})
+}
@@ -0,0 +1,149 @@
+package univedo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// CredentialsExpiredCode is the default error code of calls rejected because the session credentials expired
+// The univedo protocol does not document this code, it is an assumption. Use
+// WithCredentialsExpiredCode if the server sends another one.
+const CredentialsExpiredCode = "credentials_expired"
+
+// WithCredentialsExpiredCode sets the error code the server rejects calls with once session credentials expired
+// Sessions opened with a provider are renewed when a call fails with this code.
+func WithCredentialsExpiredCode(code string) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.credentialsExpiredCode = code
+	}
+}
+
+// A CredentialsProvider supplies the credentials to open sessions on a bucket
+// Credentials is called again to reopen sessions after a reconnect or when the
+// server rejects the credentials as expired, so providers can hand out fresh tokens.
+type CredentialsProvider interface {
+	Credentials(ctx context.Context, bucket string) (map[string]interface{}, error)
+}
+
+// CredentialsFunc is a callback used as a CredentialsProvider
+type CredentialsFunc func(ctx context.Context, bucket string) (map[string]interface{}, error)
+
+// Credentials calls f
+func (f CredentialsFunc) Credentials(ctx context.Context, bucket string) (map[string]interface{}, error) {
+	return f(ctx, bucket)
+}
+
+// StaticCredentials always provides the same credentials
+func StaticCredentials(creds map[string]interface{}) CredentialsProvider {
+	return CredentialsFunc(func(context.Context, string) (map[string]interface{}, error) {
+		return creds, nil
+	})
+}
+
+// EnvCredentials provides credentials from environment variables starting with prefix
+// The keys are the rest of the variable names in lower case, e.g. with the prefix
+// "UNIVEDO_" the variable UNIVEDO_USERNAME=marvin provides {"username": "marvin"}.
+func EnvCredentials(prefix string) CredentialsProvider {
+	return CredentialsFunc(func(context.Context, string) (map[string]interface{}, error) {
+		creds := map[string]interface{}{}
+		for _, env := range os.Environ() {
+			kv := strings.SplitN(env, "=", 2)
+			if len(kv) == 2 && strings.HasPrefix(kv[0], prefix) && len(kv[0]) > len(prefix) {
+				creds[strings.ToLower(kv[0][len(prefix):])] = kv[1]
+			}
+		}
+		if len(creds) == 0 {
+			return nil, errors.New("univedo: no credentials in environment variables " + prefix + "*")
+		}
+		return creds, nil
+	})
+}
+
+// FileCredentials provides credentials from a JSON object in a file
+// The file is read for every session, so it can be updated with new tokens.
+func FileCredentials(path string) CredentialsProvider {
+	return CredentialsFunc(func(context.Context, string) (map[string]interface{}, error) {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		creds := map[string]interface{}{}
+		if err := json.Unmarshal(b, &creds); err != nil {
+			return nil, err
+		}
+		return creds, nil
+	})
+}
+
+// IsCredentialsExpired reports whether err is a remote error rejecting expired credentials
+// The error code is the one set with WithCredentialsExpiredCode on the connection
+// the error was received on, CredentialsExpiredCode by default.
+func IsCredentialsExpired(err error) bool {
+	var remoteErr *RemoteError
+	return errors.As(err, &remoteErr) && remoteErr.expired
+}
+
+// expiredCode returns the error code the server rejects calls with once credentials expired
+func (c *Connection) expiredCode() string {
+	return c.credentialsExpiredCode
+}
+
+// renewExpired renews the credentials of the session of ro and sends call again
+// It reports whether err rejected the call because the credentials expired, in which
+// case the future of the call is resolved by the second attempt. Calls are only
+// sent again once and only on remote objects opened through a session.
+func (c *Connection) renewExpired(ro *BasicRemoteObject, call *pendingCall, err *RemoteError) bool {
+	s := ro.getOwner()
+	if s == nil || call.renewed || !err.expired {
+		return false
+	}
+	call.renewed = true
+	go func() {
+		if err := c.renewSession(call.ctx, s, call.generation); err != nil {
+			call.future.resolve(nil, err)
+			return
+		}
+		ro.sendCall(call)
+	}()
+	return true
+}
+
+// renewSession reopens s with fresh credentials unless it was renewed since generation
+// The expired session and perspectives are deleted on the server once the calls
+// sent to them before are answered.
+func (c *Connection) renewSession(ctx context.Context, s *Session, generation uint64) error {
+	s.authMutex.Lock()
+	defer s.authMutex.Unlock()
+	if s.authGeneration != generation {
+		return nil
+	}
+
+	expired := map[uint64]RemoteObject{s.ID(): s}
+	s.perspectivesMutex.Lock()
+	for _, p := range s.perspectives {
+		expired[p.ro.ID()] = p.ro
+	}
+	s.perspectivesMutex.Unlock()
+
+	if _, err := c.reopenSession(ctx, s, true); err != nil {
+		return err
+	}
+	s.authGeneration++
+
+	for id, ro := range expired {
+		id, ro := id, ro
+		release := func() { c.retireRemoteObject(id, ro) }
+		if r, ok := ro.(interface {
+			retire(uint64, func())
+		}); ok {
+			r.retire(id, release)
+		} else {
+			release()
+		}
+	}
+	return nil
+}
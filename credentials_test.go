@@ -0,0 +1,305 @@
+package univedo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/univedo/univedo-go/univedotest"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCredentialsProviders(t *testing.T) {
+	Convey("credentials providers", t, func() {
+		ctx := context.Background()
+
+		Convey("static", func() {
+			creds, err := StaticCredentials(map[string]interface{}{"username": "marvin"}).Credentials(ctx, "bucket")
+			So(err, ShouldBeNil)
+			So(creds, ShouldResemble, map[string]interface{}{"username": "marvin"})
+		})
+
+		Convey("environment", func() {
+			os.Setenv("UNIVEDO_TEST_USERNAME", "marvin")
+			os.Setenv("UNIVEDO_TEST_TOKEN", "42")
+			defer os.Unsetenv("UNIVEDO_TEST_USERNAME")
+			defer os.Unsetenv("UNIVEDO_TEST_TOKEN")
+			creds, err := EnvCredentials("UNIVEDO_TEST_").Credentials(ctx, "bucket")
+			So(err, ShouldBeNil)
+			So(creds, ShouldResemble, map[string]interface{}{"username": "marvin", "token": "42"})
+
+			_, err = EnvCredentials("UNIVEDO_MISSING_").Credentials(ctx, "bucket")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("file", func() {
+			dir, err := ioutil.TempDir("", "univedo")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "creds.json")
+			So(ioutil.WriteFile(path, []byte(`{"username": "marvin"}`), 0600), ShouldBeNil)
+			creds, err := FileCredentials(path).Credentials(ctx, "bucket")
+			So(err, ShouldBeNil)
+			So(creds, ShouldResemble, map[string]interface{}{"username": "marvin"})
+
+			_, err = FileCredentials(filepath.Join(dir, "missing.json")).Credentials(ctx, "bucket")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("callback", func() {
+			provider := CredentialsFunc(func(ctx context.Context, bucket string) (map[string]interface{}, error) {
+				return map[string]interface{}{"bucket": bucket}, nil
+			})
+			creds, err := provider.Credentials(ctx, "bucket")
+			So(err, ShouldBeNil)
+			So(creds, ShouldResemble, map[string]interface{}{"bucket": "bucket"})
+		})
+	})
+}
+
+func TestCredentialsRenewal(t *testing.T) {
+	Convey("expired credentials", t, func() {
+		server := univedotest.NewServer()
+		defer server.Close()
+		uts, err := ioutil.ReadFile("test.uts")
+		So(err, ShouldBeNil)
+
+		var requests int32
+		var failRenewal int32
+		provider := CredentialsFunc(func(ctx context.Context, bucket string) (map[string]interface{}, error) {
+			if atomic.AddInt32(&requests, 1) > 1 && atomic.LoadInt32(&failRenewal) != 0 {
+				return nil, errors.New("token service unavailable")
+			}
+			return map[string]interface{}{"username": "marvin"}, nil
+		})
+
+		connection, err := Dial(server.URL)
+		So(err, ShouldBeNil)
+		defer connection.Close()
+		session, err := connection.GetSessionWithProvider(context.Background(), "bucket", provider)
+		So(err, ShouldBeNil)
+		So(session.ApplyUTS(string(uts)), ShouldBeNil)
+		perspective, err := session.GetPerspective("cefb4ed2-4ce3-4825-8550-b68a3c142f0a")
+		So(err, ShouldBeNil)
+		server.ExpireSessions()
+
+		Convey("are renewed through the provider", func() {
+			pong, err := session.Ping("foo")
+			So(err, ShouldBeNil)
+			So(pong, ShouldEqual, "foo")
+			So(atomic.LoadInt32(&requests), ShouldEqual, 2)
+
+			_, err = perspective.CallROM("query")
+			So(err, ShouldBeNil)
+			So(atomic.LoadInt32(&requests), ShouldEqual, 2)
+		})
+
+		Convey("fail the call if renewing fails", func() {
+			atomic.StoreInt32(&failRenewal, 1)
+			_, err := session.Ping("foo")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "token service unavailable")
+		})
+
+		Convey("are reported for sessions without a provider", func() {
+			untracked, err := connection.getSession("bucket", map[string]interface{}{})
+			So(err, ShouldBeNil)
+			server.ExpireSessions()
+			_, err = untracked.Ping("foo")
+			So(IsCredentialsExpired(err), ShouldBeTrue)
+		})
+
+		Convey("are used by the sql driver", func() {
+			conn, err := UnivedoDriver{Credentials: provider}.Open(server.URL + "bucket/cefb4ed2-4ce3-4825-8550-b68a3c142f0a")
+			So(err, ShouldBeNil)
+			So(conn.Close(), ShouldBeNil)
+			So(atomic.LoadInt32(&requests), ShouldEqual, 2)
+		})
+	})
+}
+
+// serveExpiringPeer hands out sessions, pings on the first one fail with code and deleted ids are sent to deleted
+func serveExpiringPeer(t Transport, code string, deleted chan<- uint64) {
+	var lastID uint64
+	for {
+		frame, err := t.ReceiveFrame()
+		if err != nil {
+			return
+		}
+		msg := &message{buffer: bytes.NewBuffer(frame)}
+		var data []interface{}
+		for !msg.empty() {
+			v, _ := msg.read()
+			data = append(data, v)
+		}
+		roID := data[0].(uint64)
+		if data[1] == uint64(romDelete) {
+			deleted <- roID
+			continue
+		}
+		status, result := uint64(0), interface{}(nil)
+		switch data[3] {
+		case "getSession":
+			lastID++
+			result = testRORef{"com.univedo.session", lastID}
+		case "ping":
+			result = data[4].([]interface{})[0]
+			if roID == 1 {
+				status, result = 1, map[string]interface{}{"code": code, "message": "expired"}
+			}
+		}
+		reply := &message{buffer: &bytes.Buffer{}}
+		for _, v := range []interface{}{roID, uint64(romAnswer), data[2], status, result} {
+			reply.send(v)
+		}
+		if t.SendFrame(reply.buffer.Bytes()) != nil {
+			return
+		}
+	}
+}
+
+func TestCredentialsExpiredCode(t *testing.T) {
+	Convey("credentials expired codes", t, func() {
+		client, server := Pipe()
+		deleted := make(chan uint64, 10)
+		go serveExpiringPeer(server, "token_expired", deleted)
+		var requests int32
+		provider := CredentialsFunc(func(context.Context, string) (map[string]interface{}, error) {
+			atomic.AddInt32(&requests, 1)
+			return map[string]interface{}{}, nil
+		})
+
+		Convey("renew sessions when configured and delete the expired session", func() {
+			connection := NewConnection(client, WithCredentialsExpiredCode("token_expired"))
+			defer connection.Close()
+			session, err := connection.GetSessionWithProvider(context.Background(), "bucket", provider)
+			So(err, ShouldBeNil)
+			pong, err := session.Ping("foo")
+			So(err, ShouldBeNil)
+			So(pong, ShouldEqual, "foo")
+			So(session.ID(), ShouldEqual, 2)
+			So(<-deleted, ShouldEqual, 1)
+			So(atomic.LoadInt32(&requests), ShouldEqual, 2)
+		})
+
+		Convey("leave other errors to the caller", func() {
+			connection := NewConnection(client)
+			defer connection.Close()
+			session, err := connection.GetSessionWithProvider(context.Background(), "bucket", provider)
+			So(err, ShouldBeNil)
+			_, err = session.Ping("foo")
+			var remoteErr *RemoteError
+			So(errors.As(err, &remoteErr), ShouldBeTrue)
+			So(remoteErr.Code, ShouldEqual, "token_expired")
+			So(IsCredentialsExpired(err), ShouldBeFalse)
+			So(atomic.LoadInt32(&requests), ShouldEqual, 1)
+		})
+
+		Convey("are reported with the configured code", func() {
+			connection := NewConnection(client, WithCredentialsExpiredCode("token_expired"))
+			defer connection.Close()
+			untracked, err := connection.getSession("bucket", map[string]interface{}{})
+			So(err, ShouldBeNil)
+			_, err = untracked.Ping("foo")
+			So(IsCredentialsExpired(err), ShouldBeTrue)
+		})
+	})
+}
+
+func TestRenewalWithCallsInFlight(t *testing.T) {
+	Convey("renewing expired credentials with calls in flight", t, func() {
+		client, server := Pipe()
+		// Calls are sent before CallROMAsync returns, so frames are received in the background
+		frames := make(chan []interface{}, 16)
+		go func() {
+			for {
+				frame, err := server.ReceiveFrame()
+				if err != nil {
+					close(frames)
+					return
+				}
+				msg := &message{buffer: bytes.NewBuffer(frame)}
+				var data []interface{}
+				for !msg.empty() {
+					v, _ := msg.read()
+					data = append(data, v)
+				}
+				frames <- data
+			}
+		}()
+		receive := func() []interface{} {
+			select {
+			case data := <-frames:
+				return data
+			case <-time.After(time.Second):
+				return nil
+			}
+		}
+		answer := func(roID, callID, status uint64, result interface{}) {
+			reply := &message{buffer: &bytes.Buffer{}}
+			for _, v := range []interface{}{roID, uint64(romAnswer), callID, status, result} {
+				reply.send(v)
+			}
+			So(server.SendFrame(reply.buffer.Bytes()), ShouldBeNil)
+		}
+		expired := map[string]interface{}{"code": CredentialsExpiredCode, "message": "expired"}
+
+		connection := NewConnection(client)
+		defer connection.Close()
+		opened := make(chan *Session)
+		go func() {
+			session, _ := connection.GetSessionWithProvider(context.Background(), "bucket", StaticCredentials(nil))
+			opened <- session
+		}()
+		call := receive()
+		So(call[3], ShouldEqual, "getSession")
+		answer(0, call[2].(uint64), 0, testRORef{"com.univedo.session", 1})
+		session := <-opened
+		So(session, ShouldNotBeNil)
+
+		first := session.CallROMAsync("ping", 1)
+		second := session.CallROMAsync("ping", 2)
+		firstCall, secondCall := receive(), receive()
+		So(firstCall[0], ShouldEqual, 1)
+		So(secondCall[0], ShouldEqual, 1)
+
+		Convey("keep the old id until its calls are answered", func() {
+			answer(1, firstCall[2].(uint64), 1, expired)
+			call = receive()
+			So(call[3], ShouldEqual, "getSession")
+			answer(0, call[2].(uint64), 0, testRORef{"com.univedo.session", 2})
+
+			resent := receive()
+			So(resent[:2], ShouldResemble, []interface{}{uint64(2), uint64(romCall)})
+			So(resent[4], ShouldResemble, []interface{}{uint64(1)})
+			answer(2, resent[2].(uint64), 0, "one")
+
+			// The last answer on the old id releases it, the call is sent again concurrently
+			answer(1, secondCall[2].(uint64), 1, expired)
+			resent, deleted := receive(), receive()
+			if resent[1] == uint64(romDelete) {
+				resent, deleted = deleted, resent
+			}
+			So(deleted, ShouldResemble, []interface{}{uint64(1), uint64(romDelete)})
+			So(resent[:2], ShouldResemble, []interface{}{uint64(2), uint64(romCall)})
+			So(resent[4], ShouldResemble, []interface{}{uint64(2)})
+			answer(2, resent[2].(uint64), 0, "two")
+
+			res, err := first.Wait()
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, "one")
+			res, err = second.Wait()
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, "two")
+			So(connection.Err(), ShouldBeNil)
+			So(session.ID(), ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,293 @@
+package univedo
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidDSN is wrapped by all errors of ParseDSN
+var ErrInvalidDSN = errors.New("univedo: invalid DSN")
+
+// defaultFetchSize is the number of rows buffered ahead of the reader by default
+const defaultFetchSize = 100
+
+// Query parameters of a DSN that configure the connection instead of being credentials
+const (
+	dsnDialTimeout      = "dialTimeout"
+	dsnHandshakeTimeout = "handshakeTimeout"
+	dsnTLS              = "tls"
+	dsnReconnect        = "reconnect"
+	dsnFetchSize        = "fetchSize"
+
+	// dsnCredentialPrefix marks credentials whose names collide with the parameters above
+	dsnCredentialPrefix = "credential."
+)
+
+// isDSNParameter reports whether name is a query parameter configuring the connection
+func isDSNParameter(name string) bool {
+	switch name {
+	case dsnDialTimeout, dsnHandshakeTimeout, dsnTLS, dsnReconnect, dsnFetchSize:
+		return true
+	}
+	return false
+}
+
+// Values of Config.TLS besides the empty string for plain websockets
+const (
+	TLSEnabled    = "true"
+	TLSSkipVerify = "skip-verify"
+)
+
+// Config configures connections of the SQL driver
+//
+// As a DSN it is written as
+//
+//	univedo://host:port/bucket/perspective?username=marvin&dialTimeout=5s
+//
+// where the scheme is univedo, ws or wss. All query parameters except
+// dialTimeout, handshakeTimeout, tls (true, false or skip-verify), reconnect
+// (true, false or the maximum number of attempts) and fetchSize are credentials.
+// Credentials named like one of these parameters or starting with "credential."
+// are written with the prefix "credential.", e.g. credential.tls=foo. Bucket and
+// perspective are path escaped.
+type Config struct {
+	// Host and port of the server
+	Host        string
+	Bucket      string
+	Perspective string
+
+	// Credentials for the session, see also CredentialsProvider
+	Credentials map[string]string
+
+	// TLS is empty for plain websockets, TLSEnabled or TLSSkipVerify
+	TLS string
+
+	DialTimeout      time.Duration
+	HandshakeTimeout time.Duration
+
+	// Reconnect lost connections, giving up after ReconnectAttempts if not 0
+	Reconnect         bool
+	ReconnectAttempts int
+
+	// FetchSize is the number of rows buffered ahead of the reader, 100 if 0
+	FetchSize int
+
+	// The fields below are not part of the DSN and only used by connectors
+
+	// CredentialsProvider is used instead of Credentials if set
+	CredentialsProvider CredentialsProvider
+
+	// DialOptions are applied after the options from the fields above
+	DialOptions []DialOption
+
+	// Connection is shared by all connections of the connector instead of
+	// dialing the server. Host, TLS, timeouts, reconnects, fetch size and
+	// DialOptions do not apply then, and closing the connections of the
+	// connector leaves it open.
+	Connection *Connection
+}
+
+// invalidDSN returns an error wrapping ErrInvalidDSN
+func invalidDSN(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidDSN, fmt.Sprintf(format, args...))
+}
+
+// ParseDSN parses a data source name as described in Config
+func ParseDSN(dsn string) (*Config, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		// The URL error would repeat the DSN including credentials
+		return nil, invalidDSN("malformed URL")
+	}
+
+	cfg := &Config{Host: u.Host, Credentials: map[string]string{}}
+	switch u.Scheme {
+	case "univedo", "ws":
+	case "wss":
+		cfg.TLS = TLSEnabled
+	default:
+		return nil, invalidDSN("unsupported scheme %q, use univedo, ws or wss", u.Scheme)
+	}
+	if cfg.Host == "" {
+		return nil, invalidDSN("missing host")
+	}
+
+	// Split before unescaping, bucket and perspective may contain escaped slashes
+	pathComponents := strings.Split(strings.Trim(u.EscapedPath(), "/"), "/")
+	if len(pathComponents) != 2 || pathComponents[0] == "" || pathComponents[1] == "" {
+		return nil, invalidDSN("path must be /bucket/perspective")
+	}
+	if cfg.Bucket, err = url.PathUnescape(pathComponents[0]); err != nil {
+		return nil, invalidDSN("malformed bucket")
+	}
+	if cfg.Perspective, err = url.PathUnescape(pathComponents[1]); err != nil {
+		return nil, invalidDSN("malformed perspective")
+	}
+
+	for k, v := range u.Query() {
+		value := v[0]
+		if len(v) > 1 {
+			return nil, invalidDSN("parameter %s given more than once", k)
+		}
+		switch k {
+		case dsnDialTimeout, dsnHandshakeTimeout:
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return nil, invalidDSN("%s must be a duration, e.g. 5s", k)
+			}
+			if k == dsnDialTimeout {
+				cfg.DialTimeout = d
+			} else {
+				cfg.HandshakeTimeout = d
+			}
+
+		case dsnTLS:
+			switch value {
+			case "false":
+				value = ""
+			case TLSEnabled, TLSSkipVerify:
+			default:
+				return nil, invalidDSN("tls must be true, false or skip-verify")
+			}
+			if u.Scheme == "wss" && value == "" {
+				return nil, invalidDSN("tls=false contradicts the wss scheme")
+			}
+			if u.Scheme == "ws" && value != "" {
+				return nil, invalidDSN("tls=%s contradicts the ws scheme", value)
+			}
+			cfg.TLS = value
+
+		case dsnReconnect:
+			// Only the literals are booleans, "1" is a single attempt as written by FormatDSN
+			if value == "true" || value == "false" {
+				cfg.Reconnect = value == "true"
+				break
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, invalidDSN("reconnect must be true, false or a number of attempts")
+			}
+			cfg.Reconnect = true
+			cfg.ReconnectAttempts = n
+
+		case dsnFetchSize:
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, invalidDSN("fetchSize must be a positive number")
+			}
+			cfg.FetchSize = n
+
+		default:
+			name := strings.TrimPrefix(k, dsnCredentialPrefix)
+			if name == "" {
+				return nil, invalidDSN("missing credential name")
+			}
+			if _, ok := cfg.Credentials[name]; ok {
+				return nil, invalidDSN("credential %s given more than once", name)
+			}
+			cfg.Credentials[name] = value
+		}
+	}
+	return cfg, nil
+}
+
+// FormatDSN returns the data source name of the config
+// Parsing it with ParseDSN returns an equal config.
+func (cfg *Config) FormatDSN() string {
+	query := url.Values{}
+	for k, v := range cfg.Credentials {
+		if isDSNParameter(k) || strings.HasPrefix(k, dsnCredentialPrefix) {
+			k = dsnCredentialPrefix + k
+		}
+		query.Set(k, v)
+	}
+	if cfg.TLS == TLSSkipVerify {
+		query.Set(dsnTLS, TLSSkipVerify)
+	}
+	if cfg.DialTimeout > 0 {
+		query.Set(dsnDialTimeout, cfg.DialTimeout.String())
+	}
+	if cfg.HandshakeTimeout > 0 {
+		query.Set(dsnHandshakeTimeout, cfg.HandshakeTimeout.String())
+	}
+	if cfg.Reconnect {
+		if cfg.ReconnectAttempts > 0 {
+			query.Set(dsnReconnect, strconv.Itoa(cfg.ReconnectAttempts))
+		} else {
+			query.Set(dsnReconnect, "true")
+		}
+	}
+	if cfg.FetchSize > 0 {
+		query.Set(dsnFetchSize, strconv.Itoa(cfg.FetchSize))
+	}
+
+	scheme := "univedo"
+	if cfg.TLS == TLSEnabled || cfg.TLS == TLSSkipVerify {
+		scheme = "wss"
+	}
+	u := url.URL{
+		Scheme:   scheme,
+		Host:     cfg.Host,
+		Path:     "/" + cfg.Bucket + "/" + cfg.Perspective,
+		RawPath:  "/" + url.PathEscape(cfg.Bucket) + "/" + url.PathEscape(cfg.Perspective),
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+// serverURL returns the websocket URL of the server
+func (cfg *Config) serverURL() string {
+	scheme := "ws"
+	if cfg.TLS == TLSEnabled || cfg.TLS == TLSSkipVerify {
+		scheme = "wss"
+	}
+	return (&url.URL{Scheme: scheme, Host: cfg.Host, Path: "/"}).String()
+}
+
+// dialOptions returns the options to dial connections as configured
+func (cfg *Config) dialOptions() []DialOption {
+	var opts []DialOption
+	if cfg.DialTimeout > 0 {
+		opts = append(opts, WithDialTimeout(cfg.DialTimeout))
+	}
+	if cfg.HandshakeTimeout > 0 {
+		opts = append(opts, WithHandshakeTimeout(cfg.HandshakeTimeout))
+	}
+	if cfg.TLS == TLSSkipVerify {
+		opts = append(opts, WithTLSConfig(&tls.Config{InsecureSkipVerify: true}))
+	}
+	if cfg.Reconnect {
+		policy := DefaultReconnectPolicy()
+		policy.MaxAttempts = cfg.ReconnectAttempts
+		opts = append(opts, WithReconnect(policy))
+	}
+	if cfg.FetchSize > 0 {
+		opts = append(opts, withFetchSize(cfg.FetchSize))
+	}
+	return append(opts, cfg.DialOptions...)
+}
+
+// withFetchSize makes results buffer n rows ahead of the reader
+// It applies on top of the registry of the connection, see newDialConfig.
+func withFetchSize(n int) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.fetchSize = n
+	}
+}
+
+// credentials returns a provider for the credentials of the config
+func (cfg *Config) credentials() CredentialsProvider {
+	if cfg.CredentialsProvider != nil {
+		return cfg.CredentialsProvider
+	}
+	creds := make(map[string]interface{}, len(cfg.Credentials))
+	for k, v := range cfg.Credentials {
+		creds[k] = v
+	}
+	return StaticCredentials(creds)
+}
@@ -0,0 +1,135 @@
+package univedo
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDSN(t *testing.T) {
+	Convey("DSNs", t, func() {
+		Convey("parse bucket, perspective and credentials", func() {
+			cfg, err := ParseDSN("univedo://localhost:9011/bucket/perspective?username=marvin&password=secret")
+			So(err, ShouldBeNil)
+			So(cfg, ShouldResemble, &Config{
+				Host:        "localhost:9011",
+				Bucket:      "bucket",
+				Perspective: "perspective",
+				Credentials: map[string]string{"username": "marvin", "password": "secret"},
+			})
+			So(cfg.serverURL(), ShouldEqual, "ws://localhost:9011/")
+		})
+
+		Convey("parse reserved parameters", func() {
+			cfg, err := ParseDSN("univedo://localhost/b/p?username=marvin&dialTimeout=5s&handshakeTimeout=250ms&tls=skip-verify&reconnect=3&fetchSize=10")
+			So(err, ShouldBeNil)
+			So(cfg.Credentials, ShouldResemble, map[string]string{"username": "marvin"})
+			So(cfg.DialTimeout, ShouldEqual, 5*time.Second)
+			So(cfg.HandshakeTimeout, ShouldEqual, 250*time.Millisecond)
+			So(cfg.TLS, ShouldEqual, TLSSkipVerify)
+			So(cfg.Reconnect, ShouldBeTrue)
+			So(cfg.ReconnectAttempts, ShouldEqual, 3)
+			So(cfg.FetchSize, ShouldEqual, 10)
+			So(cfg.serverURL(), ShouldEqual, "wss://localhost/")
+
+			dialCfg := newDialConfig(cfg.dialOptions())
+			So(dialCfg.dialTimeout, ShouldEqual, 5*time.Second)
+			So(dialCfg.handshakeTimeout, ShouldEqual, 250*time.Millisecond)
+			So(dialCfg.tlsConfig.InsecureSkipVerify, ShouldBeTrue)
+			So(dialCfg.reconnect.MaxAttempts, ShouldEqual, 3)
+			r := dialCfg.registry.factory("com.univedo.result")(1, nil).(*result)
+			So(cap(r.rows), ShouldEqual, 10)
+		})
+
+		Convey("map schemes to TLS", func() {
+			cfg, err := ParseDSN("wss://localhost/b/p")
+			So(err, ShouldBeNil)
+			So(cfg.TLS, ShouldEqual, TLSEnabled)
+			cfg, err = ParseDSN("univedo://localhost/b/p?tls=true")
+			So(err, ShouldBeNil)
+			So(cfg.serverURL(), ShouldEqual, "wss://localhost/")
+			cfg, err = ParseDSN("ws://localhost/b/p?tls=false")
+			So(err, ShouldBeNil)
+			So(cfg.TLS, ShouldEqual, "")
+		})
+
+		Convey("round trip through FormatDSN", func() {
+			for _, dsn := range []string{
+				"univedo://localhost:9011/bucket/perspective?username=marvin",
+				"wss://localhost/b/p?fetchSize=5&reconnect=true",
+				"univedo://localhost/b/p?dialTimeout=1m0s&reconnect=2&tls=skip-verify",
+			} {
+				cfg, err := ParseDSN(dsn)
+				So(err, ShouldBeNil)
+				again, err := ParseDSN(cfg.FormatDSN())
+				So(err, ShouldBeNil)
+				So(again, ShouldResemble, cfg)
+			}
+			cfg := &Config{Host: "localhost", Bucket: "b", Perspective: "p", TLS: TLSEnabled, Credentials: map[string]string{"token": "a b"}}
+			So(cfg.FormatDSN(), ShouldEqual, "wss://localhost/b/p?token=a+b")
+
+			for _, attempts := range []int{1, 7} {
+				cfg := &Config{Host: "localhost", Bucket: "b", Perspective: "p", Credentials: map[string]string{}, Reconnect: true, ReconnectAttempts: attempts}
+				again, err := ParseDSN(cfg.FormatDSN())
+				So(err, ShouldBeNil)
+				So(again, ShouldResemble, cfg)
+			}
+		})
+
+		Convey("escape bucket and perspective", func() {
+			cfg := &Config{Host: "localhost", Bucket: "my/bucket", Perspective: "a b?", Credentials: map[string]string{}}
+			So(cfg.FormatDSN(), ShouldEqual, "univedo://localhost/my%2Fbucket/a%20b%3F")
+			again, err := ParseDSN(cfg.FormatDSN())
+			So(err, ShouldBeNil)
+			So(again, ShouldResemble, cfg)
+		})
+
+		Convey("prefix credentials named like parameters", func() {
+			cfg := &Config{Host: "localhost", Bucket: "b", Perspective: "p", Credentials: map[string]string{
+				"tls":           "token",
+				"credential.id": "x",
+			}}
+			So(cfg.FormatDSN(), ShouldEqual, "univedo://localhost/b/p?credential.credential.id=x&credential.tls=token")
+			again, err := ParseDSN(cfg.FormatDSN())
+			So(err, ShouldBeNil)
+			So(again, ShouldResemble, cfg)
+
+			again, err = ParseDSN("univedo://localhost/b/p?credential.username=marvin")
+			So(err, ShouldBeNil)
+			So(again.Credentials, ShouldResemble, map[string]string{"username": "marvin"})
+		})
+
+		Convey("apply the fetch size on top of other registries", func() {
+			cfg := &Config{FetchSize: 10, DialOptions: []DialOption{WithRegistry(NewRegistry())}}
+			r := newDialConfig(cfg.dialOptions()).registry.factory("com.univedo.result")(1, nil).(*result)
+			So(cap(r.rows), ShouldEqual, 10)
+		})
+
+		Convey("reject invalid DSNs", func() {
+			for _, dsn := range []string{
+				"http://localhost/b/p",
+				"univedo:///b/p",
+				"univedo://localhost/b",
+				"univedo://localhost/b/p/x",
+				"univedo://localhost/b/p?dialTimeout=soon",
+				"univedo://localhost/b/p?tls=maybe",
+				"ws://localhost/b/p?tls=true",
+				"wss://localhost/b/p?tls=false",
+				"univedo://localhost/b/p?reconnect=-1",
+				"univedo://localhost/b/p?reconnect=0",
+				"univedo://localhost/b/p?reconnect=TRUE",
+				"univedo://localhost/b/p?fetchSize=-1",
+				"univedo://localhost/b/p?username=a&username=b",
+				"univedo://local host/b/p?password=secret",
+				"univedo://localhost/b/p?username=a&credential.username=b",
+				"univedo://localhost/b/p?credential.=secret",
+			} {
+				_, err := ParseDSN(dsn)
+				So(errors.Is(err, ErrInvalidDSN), ShouldBeTrue)
+				So(err.Error(), ShouldNotContainSubstring, "secret")
+			}
+		})
+	})
+}
@@ -0,0 +1,85 @@
+package univedo_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/univedo/univedo-go"
+	"github.com/univedo/univedo-go/univedotest"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// perspective wraps the perspectives of a univedo server
+type perspective struct {
+	*univedo.BasicRemoteObject
+}
+
+func newPerspective(id uint64, s univedo.Sender) univedo.RemoteObject {
+	return &perspective{univedo.NewBasicRO(id, s)}
+}
+
+// Query starts a query in the perspective
+func (p *perspective) Query() (univedo.RemoteObject, error) {
+	query, err := p.CallROM("query")
+	if err != nil {
+		return nil, err
+	}
+	ro, ok := query.(univedo.RemoteObject)
+	if !ok {
+		return nil, errors.New("expected RO as return value")
+	}
+	return ro, nil
+}
+
+func ExampleRegistry() {
+	registry := univedo.NewRegistry()
+	registry.Register("com.univedo.perspective", newPerspective)
+
+	connection, err := univedo.Dial("ws://localhost:9011/", univedo.WithRegistry(registry))
+	if err != nil {
+		panic(err)
+	}
+	defer connection.Close()
+	session, err := connection.GetSession("bucket", map[string]interface{}{"username": "marvin"})
+	if err != nil {
+		panic(err)
+	}
+	p, err := session.GetPerspective("cefb4ed2-4ce3-4825-8550-b68a3c142f0a")
+	if err != nil {
+		panic(err)
+	}
+	query, err := p.(*perspective).Query()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(query.Name())
+}
+
+func TestCustomRemoteObjects(t *testing.T) {
+	Convey("remote objects of other packages", t, func() {
+		server := univedotest.NewServer()
+		defer server.Close()
+		registry := univedo.NewRegistry()
+		registry.Register("com.univedo.perspective", newPerspective)
+		connection, err := univedo.Dial(server.URL, univedo.WithRegistry(registry))
+		So(err, ShouldBeNil)
+		defer connection.Close()
+		session, err := connection.GetSession("bucket", nil)
+		So(err, ShouldBeNil)
+		uts, err := ioutil.ReadFile("test.uts")
+		So(err, ShouldBeNil)
+		So(session.ApplyUTS(string(uts)), ShouldBeNil)
+
+		Convey("are created by registered factories", func() {
+			p, err := session.GetPerspective("cefb4ed2-4ce3-4825-8550-b68a3c142f0a")
+			So(err, ShouldBeNil)
+			So(p, ShouldHaveSameTypeAs, &perspective{})
+			query, err := p.(*perspective).Query()
+			So(err, ShouldBeNil)
+			So(query.Name(), ShouldEqual, "com.univedo.query")
+		})
+	})
+}
@@ -0,0 +1,144 @@
+package univedo
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A CallHandler answers remote method calls the server makes on an exported object
+type CallHandler interface {
+	HandleCall(method string, args []interface{}) (interface{}, error)
+}
+
+// CallHandlerFunc adapts a function to the CallHandler interface
+type CallHandlerFunc func(method string, args []interface{}) (interface{}, error)
+
+// HandleCall calls f(method, args)
+func (f CallHandlerFunc) HandleCall(method string, args []interface{}) (interface{}, error) {
+	return f(method, args)
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// reflectHandler dispatches remote method calls to the exported methods of a Go value
+type reflectHandler struct {
+	v reflect.Value
+}
+
+// newReflectHandler wraps obj so that its exported methods can be called remotely
+func newReflectHandler(obj interface{}) (*reflectHandler, error) {
+	v := reflect.ValueOf(obj)
+	if !v.IsValid() {
+		return nil, errors.New("cannot export nil")
+	}
+	if v.NumMethod() == 0 {
+		return nil, fmt.Errorf("%s has no exported methods", v.Type())
+	}
+	return &reflectHandler{v: v}, nil
+}
+
+// method finds the Go method for a remote method name, e.g. Ping for ping
+func (h *reflectHandler) method(name string) (reflect.Value, bool) {
+	if m := h.v.MethodByName(name); m.IsValid() {
+		return m, true
+	}
+	r, n := utf8.DecodeRuneInString(name)
+	if r == utf8.RuneError || unicode.IsUpper(r) {
+		return reflect.Value{}, false
+	}
+	m := h.v.MethodByName(string(unicode.ToUpper(r)) + name[n:])
+	return m, m.IsValid()
+}
+
+func (h *reflectHandler) HandleCall(method string, args []interface{}) (interface{}, error) {
+	m, ok := h.method(method)
+	if !ok {
+		return nil, errors.New("unknown method " + method)
+	}
+
+	t := m.Type()
+	if t.IsVariadic() {
+		return nil, errors.New("cannot call variadic method " + method)
+	}
+	if t.NumIn() != len(args) {
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", method, t.NumIn(), len(args))
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		v, err := convertValue(arg, t.In(i))
+		if err != nil {
+			return nil, fmt.Errorf("argument %d of %s: %s", i, method, err.Error())
+		}
+		in[i] = v
+	}
+
+	out := m.Call(in)
+	if len(out) > 0 && t.Out(len(out)-1) == errorType {
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			return nil, err
+		}
+		out = out[:len(out)-1]
+	}
+	switch len(out) {
+	case 0:
+		return nil, nil
+	case 1:
+		return out[0].Interface(), nil
+	default:
+		results := make([]interface{}, len(out))
+		for i, v := range out {
+			results[i] = v.Interface()
+		}
+		return results, nil
+	}
+}
+
+// convertValue converts a value received from the protocol into the Go type t
+func convertValue(value interface{}, t reflect.Type) (reflect.Value, error) {
+	if value == nil {
+		return reflect.Zero(t), nil
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Type().AssignableTo(t) {
+		return v, nil
+	}
+
+	switch {
+	case isNumber(v.Kind()) && isNumber(t.Kind()):
+		return v.Convert(t), nil
+
+	case v.Kind() == reflect.Slice && t.Kind() == reflect.Slice:
+		s := reflect.MakeSlice(t, v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			e, err := convertValue(v.Index(i).Interface(), t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			s.Index(i).Set(e)
+		}
+		return s, nil
+
+	case v.Kind() == reflect.Map && t.Kind() == reflect.Map && t.Key().Kind() == reflect.String:
+		m := reflect.MakeMapWithSize(t, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			e, err := convertValue(iter.Value().Interface(), t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			m.SetMapIndex(iter.Key().Convert(t.Key()), e)
+		}
+		return m, nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot convert %s to %s", v.Type(), t)
+}
+
+func isNumber(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Float64
+}
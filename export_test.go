@@ -0,0 +1,154 @@
+package univedo
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testCallback struct {
+	calls int
+}
+
+func (c *testCallback) Ping(v string) string {
+	c.calls++
+	return v
+}
+
+func (c *testCallback) Add(a, b int) (int, error) {
+	if a < 0 {
+		return 0, errors.New("negative")
+	}
+	return a + b, nil
+}
+
+func (c *testCallback) Notify() {
+	c.calls++
+}
+
+func (c *testCallback) Boom() {
+	panic("boom")
+}
+
+type testPoint struct {
+	X, Y int
+}
+
+func (c *testCallback) Origin() testPoint {
+	return testPoint{}
+}
+
+func TestExport(t *testing.T) {
+	Convey("exported objects", t, func() {
+		c := &Connection{remoteObjects: make(map[uint64]RemoteObject)}
+
+		Convey("call methods by reflection", func() {
+			h, err := newReflectHandler(&testCallback{})
+			So(err, ShouldBeNil)
+			res, err := h.HandleCall("ping", []interface{}{"foo"})
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, "foo")
+			res, err = h.HandleCall("add", []interface{}{uint64(1), int64(-3)})
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, -2)
+			res, err = h.HandleCall("notify", []interface{}{})
+			So(err, ShouldBeNil)
+			So(res, ShouldBeNil)
+		})
+
+		Convey("return errors from methods", func() {
+			h, err := newReflectHandler(&testCallback{})
+			So(err, ShouldBeNil)
+			_, err = h.HandleCall("add", []interface{}{int64(-1), uint64(1)})
+			So(err, ShouldNotBeNil)
+			_, err = h.HandleCall("unknown", []interface{}{})
+			So(err, ShouldNotBeNil)
+			_, err = h.HandleCall("ping", []interface{}{})
+			So(err, ShouldNotBeNil)
+			_, err = h.HandleCall("ping", []interface{}{uint64(1)})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("answer calls from the server", func() {
+			s := new(testSession)
+			done := make(chan struct{})
+			s.onMessage = func() { close(done) }
+			ro, err := c.Export(100, "com.example.callback", &testCallback{})
+			So(err, ShouldBeNil)
+			ro.session = s
+			So(c.remoteObject(100), ShouldEqual, ro)
+			err = ro.receive([]interface{}{uint64(1), uint64(7), "ping", []interface{}{"pong"}})
+			So(err, ShouldBeNil)
+			<-done
+			So(s.msg, ShouldResemble, []interface{}{uint64(100), uint64(2), uint64(7), uint64(0), "pong"})
+		})
+
+		Convey("answer failing calls with status 1", func() {
+			s := new(testSession)
+			done := make(chan struct{})
+			s.onMessage = func() { close(done) }
+			ro, err := c.Export(100, "com.example.callback", &testCallback{})
+			So(err, ShouldBeNil)
+			ro.session = s
+			err = ro.receive([]interface{}{uint64(1), uint64(7), "add", []interface{}{int64(-1), uint64(2)}})
+			So(err, ShouldBeNil)
+			<-done
+			So(s.msg, ShouldResemble, []interface{}{uint64(100), uint64(2), uint64(7), uint64(1), "negative"})
+		})
+
+		Convey("answer panicking calls with status 1", func() {
+			s := new(testSession)
+			done := make(chan struct{})
+			s.onMessage = func() { close(done) }
+			ro, err := c.Export(100, "com.example.callback", &testCallback{})
+			So(err, ShouldBeNil)
+			ro.session = s
+			err = ro.receive([]interface{}{uint64(1), uint64(7), "boom", []interface{}{}})
+			So(err, ShouldBeNil)
+			<-done
+			So(s.msg[:4], ShouldResemble, []interface{}{uint64(100), uint64(2), uint64(7), uint64(1)})
+			So(s.msg[4], ShouldContainSubstring, "boom panicked")
+		})
+
+		Convey("answer calls with results that cannot be sent with status 1", func() {
+			s := new(testSession)
+			done := make(chan struct{})
+			s.onMessage = func() { close(done) }
+			ro, err := c.Export(100, "com.example.callback", &testCallback{})
+			So(err, ShouldBeNil)
+			ro.session = s
+			err = ro.receive([]interface{}{uint64(1), uint64(7), "origin", []interface{}{}})
+			So(err, ShouldBeNil)
+			<-done
+			So(s.msg[:4], ShouldResemble, []interface{}{uint64(100), uint64(2), uint64(7), uint64(1)})
+			So(s.msg[4], ShouldContainSubstring, "cannot send result of origin")
+		})
+
+		Convey("are not replaced by remote objects of the server", func() {
+			c.registry = NewRegistry()
+			ro, err := c.Export(100, "com.example.callback", &testCallback{})
+			So(err, ShouldBeNil)
+			_, err = c.receiveRO(100, "com.example.other")
+			So(err, ShouldNotBeNil)
+			So(c.remoteObject(100), ShouldEqual, ro)
+		})
+
+		Convey("refuse duplicate ids", func() {
+			_, err := c.Export(100, "a", &testCallback{})
+			So(err, ShouldBeNil)
+			_, err = c.Export(100, "b", &testCallback{})
+			So(err, ShouldNotBeNil)
+			c.Unexport(100)
+			So(c.remoteObject(100), ShouldBeNil)
+		})
+
+		Convey("are sent as remote objects", func() {
+			ro, err := c.Export(100, "com.example.callback", &testCallback{})
+			So(err, ShouldBeNil)
+			b, err := sendMessage(ro)
+			So(err, ShouldBeNil)
+			So(b, ShouldResemble, []byte("\xd8\x1b\x82\x74com.example.callback\x18\x64"))
+		})
+	})
+}
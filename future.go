@@ -0,0 +1,84 @@
+package univedo
+
+import (
+	"errors"
+)
+
+// PipeliningCapability is announced by servers accepting calls on the results of calls still in flight
+// Such a call is sent as [roID, 1, callID, name, args, promisedCallID] and runs on
+// the remote object returned by the call promisedCallID of roID. Its answer is sent
+// on roID. Neither the capability nor the message are documented by univedo yet,
+// both are assumptions.
+const PipeliningCapability = "pipelining"
+
+// A Future is the eventual result of an asynchronous remote method call
+// Independent calls can be sent back to back and awaited later, see also
+// Future.CallROMAsync for calls on the result.
+type Future struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+
+	// cancel forgets the pending call and resolves the future with an error
+	cancel func(error)
+
+	// ro and call are set if the future belongs to a call sent without interceptors
+	ro   *BasicRemoteObject
+	call *pendingCall
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+// resolve sets the result of the future, it must only be called once
+func (f *Future) resolve(value interface{}, err error) {
+	f.value = value
+	f.err = err
+	close(f.done)
+}
+
+// abandon gives up waiting for the answer, its call no longer counts as pending
+func (f *Future) abandon(err error) {
+	if f.cancel != nil {
+		f.cancel(err)
+	}
+}
+
+// Done returns a channel that is closed once the result is available
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the call has finished and returns its result
+func (f *Future) Wait() (interface{}, error) {
+	<-f.done
+	return f.value, f.err
+}
+
+// CallROMAsync calls a method on the remote object the future resolves to
+// If the server announced PipeliningCapability, the call is sent right away
+// without waiting for the answer, so chains like query and prepare cost a single
+// round trip. Otherwise it is sent once the future resolved. If the future fails
+// or does not resolve to a remote object, so does the result.
+func (f *Future) CallROMAsync(name string, args ...interface{}) *Future {
+	if f.ro != nil && f.ro.canPipeline() {
+		return f.ro.startPipelinedCall(f.call, name, args)
+	}
+
+	next := newFuture()
+	go func() {
+		value, err := f.Wait()
+		if err != nil {
+			next.resolve(nil, err)
+			return
+		}
+		ro, ok := value.(RemoteObject)
+		if !ok {
+			next.resolve(nil, errors.New("expected RO as return value"))
+			return
+		}
+		next.resolve(ro.CallROMAsync(name, args...).Wait())
+	}()
+	return next
+}
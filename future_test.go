@@ -0,0 +1,93 @@
+package univedo
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuture(t *testing.T) {
+	Convey("futures", t, func() {
+		Convey("resolve asynchronous calls", func() {
+			s := new(testSession)
+			ro := NewBasicRO(23, s)
+			s.onMessage = func() {}
+			f := ro.CallROMAsync("foo", 1)
+			So(s.msg, ShouldResemble, []interface{}{uint64(23), uint64(1), uint64(0), "foo", []interface{}{1}})
+			select {
+			case <-f.Done():
+				t.Fatal("future resolved before the answer")
+			default:
+			}
+			ro.receive([]interface{}{uint64(2), uint64(0), uint64(0), "bar"})
+			res, err := f.Wait()
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, "bar")
+		})
+
+		Convey("send independent calls back to back", func() {
+			s := new(testSession)
+			ro := NewBasicRO(23, s)
+			s.onMessage = func() {}
+			f1 := ro.CallROMAsync("foo")
+			f2 := ro.CallROMAsync("bar")
+			So(s.msg[:4], ShouldResemble, []interface{}{uint64(23), uint64(1), uint64(1), "bar"})
+			ro.receive([]interface{}{uint64(2), uint64(1), uint64(0), "second"})
+			ro.receive([]interface{}{uint64(2), uint64(0), uint64(0), "first"})
+			res, err := f1.Wait()
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, "first")
+			res, err = f2.Wait()
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, "second")
+		})
+
+		Convey("pipeline calls on results in flight if the server is capable", func() {
+			s := &pipeliningSession{capable: true}
+			ro := NewBasicRO(23, s)
+			s.onMessage = func() {}
+			query := ro.CallROMAsync("query")
+			prepare := query.CallROMAsync("prepare", "select")
+			So(s.msg, ShouldResemble, []interface{}{uint64(23), uint64(1), uint64(1), "prepare", []interface{}{"select"}, uint64(0)})
+			ro.receive([]interface{}{uint64(2), uint64(0), uint64(0), NewBasicRO(24, s)})
+			ro.receive([]interface{}{uint64(2), uint64(1), uint64(0), "prepared"})
+			res, err := prepare.Wait()
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, "prepared")
+		})
+
+		Convey("wait for the result otherwise", func() {
+			s := &pipeliningSession{}
+			ro := NewBasicRO(23, s)
+			s.onMessage = func() {}
+			query := ro.CallROMAsync("query")
+			prepare := query.CallROMAsync("prepare", "select")
+			So(s.msg[:4], ShouldResemble, []interface{}{uint64(23), uint64(1), uint64(0), "query"})
+			ro.receive([]interface{}{uint64(2), uint64(0), uint64(1), "failed"})
+			_, err := prepare.Wait()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("fail calls on results that are no remote objects", func() {
+			s := &pipeliningSession{}
+			ro := NewBasicRO(23, s)
+			s.onMessage = func() {}
+			query := ro.CallROMAsync("query")
+			prepare := query.CallROMAsync("prepare")
+			ro.receive([]interface{}{uint64(2), uint64(0), uint64(0), "bar"})
+			_, err := prepare.Wait()
+			So(err, ShouldResemble, errors.New("expected RO as return value"))
+		})
+	})
+}
+
+// pipeliningSession is a testSession announcing PipeliningCapability if capable
+type pipeliningSession struct {
+	testSession
+	capable bool
+}
+
+func (s *pipeliningSession) HasCapability(name string) bool {
+	return s.capable && name == PipeliningCapability
+}
@@ -0,0 +1,73 @@
+package univedo
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrHeartbeatTimeout is the error of connections torn down after too many missed heartbeats
+var ErrHeartbeatTimeout = errors.New("univedo: heartbeat timeout")
+
+// heartbeat pings the server every interval until the connection is done
+// Pings call "ping" on the login object, which exists on every connection, so idle
+// connections without a session are kept alive, too. Any answer counts, including
+// an error from servers that do not know the method. After maxMissed pings in a
+// row went unanswered within the interval the transport is closed.
+func (c *Connection) heartbeat(interval time.Duration, maxMissed int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	missed := 0
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		c.mutex.Lock()
+		t, urologin := c.transport, c.urologin
+		c.mutex.Unlock()
+		if t == nil {
+			// Reconnecting
+			missed = 0
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		start := time.Now()
+		_, err := urologin.CallROMContext(ctx, "ping", nil)
+		rtt := time.Since(start)
+		cancel()
+
+		var remoteErr *RemoteError
+		if errors.As(err, &remoteErr) {
+			err = nil
+		}
+		if errors.Is(err, ErrConnectionLost) || errors.Is(err, ErrStaleRemoteObject) {
+			missed = 0
+			continue
+		}
+		if err != nil {
+			missed++
+			if missed >= maxMissed {
+				missed = 0
+				c.drop(t, ErrHeartbeatTimeout)
+			}
+			continue
+		}
+
+		missed = 0
+		c.mutex.Lock()
+		c.lastRTT = rtt
+		c.mutex.Unlock()
+	}
+}
+
+// LastRTT returns the round trip time of the last answered heartbeat, or 0 if there was none
+func (c *Connection) LastRTT() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lastRTT
+}
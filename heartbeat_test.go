@@ -0,0 +1,55 @@
+package univedo
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/univedo/univedo-go/univedotest"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHeartbeat(t *testing.T) {
+	Convey("heartbeats", t, func() {
+		var answering int32 = 1
+		client, server := Pipe()
+		pings := make(chan struct{}, 100)
+		go serveTestPeer(server, func(roID uint64, method string, args []interface{}) interface{} {
+			if roID == 0 && method == "ping" {
+				pings <- struct{}{}
+				if atomic.LoadInt32(&answering) == 0 {
+					return noAnswer
+				}
+			}
+			return nil
+		})
+		connection := NewConnection(client, WithHeartbeat(10*time.Millisecond, 2))
+		defer connection.Close()
+
+		Convey("ping idle connections on the login object", func() {
+			<-pings
+			<-pings
+			So(connection.LastRTT(), ShouldBeGreaterThan, 0)
+			So(connection.Err(), ShouldBeNil)
+		})
+
+		Convey("tear down connections after missed heartbeats", func() {
+			atomic.StoreInt32(&answering, 0)
+			<-connection.Done()
+			So(connection.Err(), ShouldEqual, ErrHeartbeatTimeout)
+		})
+	})
+
+	Convey("heartbeats count error answers", t, func() {
+		server := univedotest.NewServer()
+		defer server.Close()
+		connection, err := Dial(server.URL, WithHeartbeat(10*time.Millisecond, 1))
+		So(err, ShouldBeNil)
+		defer connection.Close()
+		for connection.LastRTT() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		So(connection.Err(), ShouldBeNil)
+	})
+}
@@ -0,0 +1,45 @@
+package univedo
+
+import (
+	"context"
+)
+
+// An Invoker performs a remote method call or sends a notification
+type Invoker func(ctx context.Context, ro RemoteObject, method string, args []interface{}) (interface{}, error)
+
+// An Interceptor is run around every remote method call and notification on a connection
+// It may inspect or change the call and must call invoke to actually perform it.
+// For notifications the result is always nil, IsNotification tells them apart.
+type Interceptor func(ctx context.Context, ro RemoteObject, method string, args []interface{}, invoke Invoker) (interface{}, error)
+
+type notificationKey struct{}
+
+// IsNotification reports whether an interceptor is run for a notification
+func IsNotification(ctx context.Context) bool {
+	n, _ := ctx.Value(notificationKey{}).(bool)
+	return n
+}
+
+// chainInterceptors combines several interceptors, the first one being the outermost
+func chainInterceptors(interceptors []Interceptor) Interceptor {
+	if len(interceptors) == 0 {
+		return nil
+	}
+	return func(ctx context.Context, ro RemoteObject, method string, args []interface{}, invoke Invoker) (interface{}, error) {
+		return chainInvoker(interceptors, invoke)(ctx, ro, method, args)
+	}
+}
+
+func chainInvoker(interceptors []Interceptor, invoke Invoker) Invoker {
+	if len(interceptors) == 0 {
+		return invoke
+	}
+	return func(ctx context.Context, ro RemoteObject, method string, args []interface{}) (interface{}, error) {
+		return interceptors[0](ctx, ro, method, args, chainInvoker(interceptors[1:], invoke))
+	}
+}
+
+// interceptorProvider is implemented by senders with interceptors, e.g. Connection
+type interceptorProvider interface {
+	interceptor() Interceptor
+}
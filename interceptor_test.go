@@ -0,0 +1,111 @@
+package univedo
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testInterceptingSession struct {
+	testSession
+	intercept Interceptor
+}
+
+func (s *testInterceptingSession) interceptor() Interceptor {
+	return s.intercept
+}
+
+func TestInterceptor(t *testing.T) {
+	Convey("interceptors", t, func() {
+		var trace []string
+		record := func(name string) Interceptor {
+			return func(ctx context.Context, ro RemoteObject, method string, args []interface{}, invoke Invoker) (interface{}, error) {
+				kind := "call"
+				if IsNotification(ctx) {
+					kind = "notification"
+				}
+				trace = append(trace, name+" "+kind+" "+method)
+				return invoke(ctx, ro, method, args)
+			}
+		}
+		s := &testInterceptingSession{intercept: chainInterceptors([]Interceptor{record("outer"), record("inner")})}
+		ro := NewBasicRO(23, s)
+
+		Convey("run in order around calls", func() {
+			s.onMessage = func() {
+				ro.receive([]interface{}{uint64(2), uint64(0), uint64(0), uint64(42)})
+			}
+			res, err := ro.CallROM("foo")
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, 42)
+			So(trace, ShouldResemble, []string{"outer call foo", "inner call foo"})
+		})
+
+		Convey("run around asynchronous calls", func() {
+			s.onMessage = func() {
+				ro.receive([]interface{}{uint64(2), uint64(0), uint64(0), uint64(42)})
+			}
+			res, err := ro.CallROMAsync("foo").Wait()
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, 42)
+			So(trace, ShouldResemble, []string{"outer call foo", "inner call foo"})
+		})
+
+		Convey("run around notifications", func() {
+			s.onMessage = func() {}
+			err := ro.SendNotification("bar", 1)
+			So(err, ShouldBeNil)
+			So(trace, ShouldResemble, []string{"outer notification bar", "inner notification bar"})
+			So(s.msg, ShouldResemble, []interface{}{uint64(23), uint64(3), "bar", []interface{}{1}})
+		})
+
+		Convey("can short-circuit calls", func() {
+			s.intercept = func(ctx context.Context, ro RemoteObject, method string, args []interface{}, invoke Invoker) (interface{}, error) {
+				return "cached", nil
+			}
+			res, err := ro.CallROM("foo")
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, "cached")
+			So(s.msg, ShouldBeNil)
+		})
+
+		Convey("keep the order of asynchronous calls", func() {
+			var mutex sync.Mutex
+			var sent []interface{}
+			s := &testOrderedSession{send: func(msg []interface{}) {
+				mutex.Lock()
+				sent = append(sent, msg[3])
+				mutex.Unlock()
+			}}
+			s.intercept = func(ctx context.Context, ro RemoteObject, method string, args []interface{}, invoke Invoker) (interface{}, error) {
+				time.Sleep(time.Millisecond)
+				return invoke(ctx, ro, method, args)
+			}
+			ro := NewBasicRO(23, s)
+			for _, method := range []string{"a", "b", "c", "d"} {
+				ro.CallROMAsync(method)
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			So(sent, ShouldResemble, []interface{}{"a", "b", "c", "d"})
+		})
+	})
+}
+
+// testOrderedSession passes every message to send right away
+type testOrderedSession struct {
+	send      func([]interface{})
+	intercept Interceptor
+}
+
+func (s *testOrderedSession) sendMessage(msg ...interface{}) error {
+	s.send(msg)
+	return nil
+}
+
+func (s *testOrderedSession) interceptor() Interceptor {
+	return s.intercept
+}
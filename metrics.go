@@ -0,0 +1,238 @@
+package univedo
+
+import (
+	"expvar"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// expvarConnections holds the metrics of all connections, keyed by connection number
+var expvarConnections = expvar.NewMap("univedo")
+
+// connectionCount numbers connections for their metrics
+var connectionCount uint64
+
+// latencyBuckets are the upper bounds of the call latency histogram buckets
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// WithoutMetrics disables publishing the metrics of the connection via expvar
+func WithoutMetrics() DialOption {
+	return func(cfg *dialConfig) {
+		cfg.disableMetrics = true
+	}
+}
+
+// histogram is an expvar.Var counting call latencies in buckets
+type histogram struct {
+	mutex   sync.Mutex
+	count   uint64
+	sum     time.Duration
+	buckets []uint64
+}
+
+func newHistogram() *histogram {
+	return &histogram{buckets: make([]uint64, len(latencyBuckets)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return d <= latencyBuckets[i] })
+	h.mutex.Lock()
+	h.count++
+	h.sum += d
+	h.buckets[i]++
+	h.mutex.Unlock()
+}
+
+// String returns the histogram as JSON, the buckets count calls up to their bound
+func (h *histogram) String() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	var b strings.Builder
+	fmt.Fprintf(&b, `{"count": %d, "sum_ms": %g, "buckets": {`, h.count, float64(h.sum)/float64(time.Millisecond))
+	for i, n := range h.buckets {
+		bound := "+Inf"
+		if i < len(latencyBuckets) {
+			bound = latencyBuckets[i].String()
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%q: %d", bound, n)
+	}
+	b.WriteString("}}")
+	return b.String()
+}
+
+// A call sent and waiting for its answer
+type metricsCall struct {
+	roID   uint64
+	callID uint64
+}
+
+// metrics counts the traffic of a connection and publishes it via expvar
+type metrics struct {
+	name string
+	vars *expvar.Map
+
+	framesSent     expvar.Int
+	framesReceived expvar.Int
+	bytesSent      expvar.Int
+	bytesReceived  expvar.Int
+	callsInFlight  expvar.Int
+	reconnects     expvar.Int
+	latency        expvar.Map
+	notifications  expvar.Map
+
+	mutex sync.Mutex
+	calls map[metricsCall]traceCall
+}
+
+// newMetrics publishes the metrics of c under the next connection number
+func newMetrics(c *Connection) *metrics {
+	m := &metrics{
+		name:  strconv.FormatUint(atomic.AddUint64(&connectionCount, 1), 10),
+		vars:  new(expvar.Map).Init(),
+		calls: make(map[metricsCall]traceCall),
+	}
+	m.latency.Init()
+	m.notifications.Init()
+	m.vars.Set("frames_sent", &m.framesSent)
+	m.vars.Set("frames_received", &m.framesReceived)
+	m.vars.Set("bytes_sent", &m.bytesSent)
+	m.vars.Set("bytes_received", &m.bytesReceived)
+	m.vars.Set("calls_in_flight", &m.callsInFlight)
+	m.vars.Set("reconnects", &m.reconnects)
+	m.vars.Set("call_latency", &m.latency)
+	m.vars.Set("notifications", &m.notifications)
+	m.vars.Set("remote_objects", expvar.Func(func() interface{} {
+		return c.liveRemoteObjects()
+	}))
+	expvarConnections.Set(m.name, m.vars)
+	return m
+}
+
+// sending is called before a message is written
+func (m *metrics) sending(roID uint64, data []interface{}) {
+	if m == nil || len(data) < 3 || data[0] != romCall {
+		return
+	}
+	callID, _ := data[1].(uint64)
+	method, _ := data[2].(string)
+	m.mutex.Lock()
+	m.calls[metricsCall{roID, callID}] = traceCall{method: method, start: time.Now()}
+	m.callsInFlight.Set(int64(len(m.calls)))
+	m.mutex.Unlock()
+}
+
+// sent is called after a message of size bytes was written, or failed with err
+func (m *metrics) sent(roID uint64, data []interface{}, size int, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		if len(data) >= 2 && data[0] == romCall {
+			callID, _ := data[1].(uint64)
+			m.answered(metricsCall{roID, callID})
+		}
+		return
+	}
+	m.framesSent.Add(1)
+	m.bytesSent.Add(int64(size))
+}
+
+// received is called for every message received
+func (m *metrics) received(roID uint64, data []interface{}, size int) {
+	if m == nil {
+		return
+	}
+	m.framesReceived.Add(1)
+	m.bytesReceived.Add(int64(size))
+	if len(data) < 2 {
+		return
+	}
+	switch data[0] {
+	case uint64(romAnswer):
+		callID, _ := data[1].(uint64)
+		if call, ok := m.answered(metricsCall{roID, callID}); ok {
+			h, ok := m.latency.Get(call.method).(*histogram)
+			if !ok {
+				// Another message may have added the histogram in the meantime
+				m.mutex.Lock()
+				if h, ok = m.latency.Get(call.method).(*histogram); !ok {
+					h = newHistogram()
+					m.latency.Set(call.method, h)
+				}
+				m.mutex.Unlock()
+			}
+			h.observe(time.Since(call.start))
+		}
+	case uint64(romNotify):
+		name, _ := data[1].(string)
+		m.notifications.Add(name, 1)
+	}
+}
+
+// answered removes a call from the calls in flight
+func (m *metrics) answered(key metricsCall) (traceCall, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	call, ok := m.calls[key]
+	delete(m.calls, key)
+	m.callsInFlight.Set(int64(len(m.calls)))
+	return call, ok
+}
+
+// lost forgets all calls in flight when the transport is gone
+func (m *metrics) lost() {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	m.calls = make(map[metricsCall]traceCall)
+	m.callsInFlight.Set(0)
+	m.mutex.Unlock()
+}
+
+func (m *metrics) reconnected() {
+	if m == nil {
+		return
+	}
+	m.reconnects.Add(1)
+}
+
+// unpublish removes the metrics of a closed connection
+func (m *metrics) unpublish() {
+	if m == nil {
+		return
+	}
+	expvarConnections.Delete(m.name)
+}
+
+// liveRemoteObjects counts the remote objects of the connection by name
+func (c *Connection) liveRemoteObjects() map[string]int {
+	c.remoteObjectsMutex.Lock()
+	defer c.remoteObjectsMutex.Unlock()
+	counts := make(map[string]int)
+	for _, ro := range c.remoteObjects {
+		name := ro.Name()
+		if name == "" {
+			name = "unnamed"
+		}
+		counts[name]++
+	}
+	return counts
+}
@@ -0,0 +1,87 @@
+package univedo
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics(t *testing.T) {
+	Convey("metrics", t, func() {
+		client, server := Pipe()
+		go serveTestPeer(server, func(roID uint64, method string, args []interface{}) interface{} {
+			if method == "getSession" {
+				return testRORef{"com.univedo.session", 1}
+			}
+			return args[0]
+		})
+		connection := NewConnection(client)
+		defer connection.Close()
+		m := connection.metrics
+
+		session, err := connection.GetSession("bucket", nil)
+		So(err, ShouldBeNil)
+		_, err = session.Ping("a")
+		So(err, ShouldBeNil)
+		_, err = session.Ping("b")
+		So(err, ShouldBeNil)
+
+		Convey("are published via expvar", func() {
+			So(expvarConnections.Get(m.name), ShouldEqual, m.vars)
+			var v map[string]interface{}
+			So(json.Unmarshal([]byte(m.vars.String()), &v), ShouldBeNil)
+			So(v["frames_sent"], ShouldEqual, 3)
+			So(v["frames_received"], ShouldEqual, 3)
+			So(v["bytes_sent"], ShouldBeGreaterThan, 0)
+			So(v["calls_in_flight"], ShouldEqual, 0)
+			So(v["remote_objects"], ShouldResemble, map[string]interface{}{"unnamed": 1.0, "com.univedo.session": 1.0})
+
+			latency := v["call_latency"].(map[string]interface{})
+			So(latency["ping"].(map[string]interface{})["count"], ShouldEqual, 2)
+			So(latency["getSession"].(map[string]interface{})["count"], ShouldEqual, 1)
+		})
+
+		Convey("count notifications", func() {
+			session.SetUnhandledNotificationHandler(func(string, []interface{}) {})
+			notify := &message{buffer: &bytes.Buffer{}}
+			for _, v := range []interface{}{uint64(1), uint64(romNotify), "changed", []interface{}{}} {
+				notify.send(v)
+			}
+			So(server.SendFrame(notify.buffer.Bytes()), ShouldBeNil)
+			// A call answered after the notification makes sure it was handled
+			_, err := session.Ping("c")
+			So(err, ShouldBeNil)
+			So(m.notifications.Get("changed").String(), ShouldEqual, "1")
+		})
+
+		Convey("are removed when the connection closes", func() {
+			connection.Close()
+			<-connection.Done()
+			So(expvarConnections.Get(m.name), ShouldBeNil)
+		})
+	})
+
+	Convey("metrics can be disabled", t, func() {
+		client, _ := Pipe()
+		connection := NewConnection(client, WithoutMetrics())
+		defer connection.Close()
+		So(connection.metrics, ShouldBeNil)
+	})
+
+	Convey("histograms count latencies in buckets", t, func() {
+		h := newHistogram()
+		h.observe(3 * time.Millisecond)
+		h.observe(time.Minute)
+		var v struct {
+			Count   int
+			Buckets map[string]int
+		}
+		So(json.Unmarshal([]byte(h.String()), &v), ShouldBeNil)
+		So(v.Count, ShouldEqual, 2)
+		So(v.Buckets["5ms"], ShouldEqual, 1)
+		So(v.Buckets["+Inf"], ShouldEqual, 1)
+	})
+}
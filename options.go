@@ -0,0 +1,139 @@
+package univedo
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// A DialOption configures a Connection
+type DialOption func(*dialConfig)
+
+// dialConfig collects the settings of all DialOptions
+type dialConfig struct {
+	interceptors []Interceptor
+	reconnect    *ReconnectPolicy
+
+	heartbeatInterval  time.Duration
+	heartbeatMaxMissed int
+
+	// websocket settings used by Dial
+	tlsConfig        *tls.Config
+	header           http.Header
+	origin           string
+	protocolPath     string
+	protocolVersions []string
+	dialTimeout      time.Duration
+	handshakeTimeout time.Duration
+	dialer           *net.Dialer
+
+	registry  *Registry
+	sendQueue int
+	fetchSize int
+
+	credentialsExpiredCode string
+
+	disableMetrics bool
+
+	// tracing
+	logger    *slog.Logger
+	redactors []Redactor
+
+	// wrapTransport wraps every transport of the connection, e.g. for recording
+	wrapTransport []func(Transport) Transport
+}
+
+func newDialConfig(opts []DialOption) *dialConfig {
+	cfg := &dialConfig{protocolPath: "v1", header: http.Header{}, registry: registeredRemoteObjects, credentialsExpiredCode: CredentialsExpiredCode}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.fetchSize > 0 {
+		// Results buffer fetchSize rows whichever registry was chosen
+		fetchSize := cfg.fetchSize
+		cfg.registry = &Registry{parent: cfg.registry, factories: map[string]func(id uint64, s Sender) RemoteObject{
+			"com.univedo.result": func(id uint64, s Sender) RemoteObject {
+				return newResultWithFetchSize(id, s, fetchSize)
+			},
+		}}
+	}
+	return cfg
+}
+
+// WithInterceptors adds interceptors run around every remote method call and notification
+func WithInterceptors(interceptors ...Interceptor) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.interceptors = append(cfg.interceptors, interceptors...)
+	}
+}
+
+// WithReconnect re-establishes lost connections according to the policy
+// Sessions and the perspectives opened with Session.GetPerspective are restored,
+// all other remote objects of the old connection fail with ErrStaleRemoteObject.
+// Zero delays of the policy are replaced by those of DefaultReconnectPolicy.
+func WithReconnect(policy *ReconnectPolicy) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.reconnect = policy.withDefaults()
+	}
+}
+
+// WithTLSConfig sets the TLS configuration for wss URLs, e.g. for private CAs or client certificates
+func WithTLSConfig(config *tls.Config) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.tlsConfig = config
+	}
+}
+
+// WithHeader adds an HTTP header to the websocket handshake, e.g. an auth token
+func WithHeader(key, value string) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.header.Add(key, value)
+	}
+}
+
+// WithOrigin sets the origin of the websocket handshake, by default http://host of the URL
+func WithOrigin(origin string) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.origin = origin
+	}
+}
+
+// WithProtocolPath sets the protocol version path appended to the URL, by default v1
+func WithProtocolPath(path string) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.protocolPath = path
+	}
+}
+
+// WithDialTimeout limits the time to establish the network connection
+func WithDialTimeout(d time.Duration) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.dialTimeout = d
+	}
+}
+
+// WithHandshakeTimeout limits the time for the TLS and websocket handshakes and the protocol negotiation
+func WithHandshakeTimeout(d time.Duration) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.handshakeTimeout = d
+	}
+}
+
+// WithDialer sets the net.Dialer used to connect, e.g. to pick a local address or keepalive
+func WithDialer(dialer *net.Dialer) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.dialer = dialer
+	}
+}
+
+// WithHeartbeat pings the server every interval to keep the connection alive and measure latency
+// If maxMissed pings in a row are not answered within the interval, the connection
+// is torn down with ErrHeartbeatTimeout, or reconnected if WithReconnect is set.
+func WithHeartbeat(interval time.Duration, maxMissed int) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.heartbeatInterval = interval
+		cfg.heartbeatMaxMissed = maxMissed
+	}
+}
@@ -0,0 +1,133 @@
+package univedo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// defaultVersion is offered if no versions are set and assumed for servers that do not support negotiation
+const defaultVersion = "v1"
+
+// negotiateTimeout bounds the negotiation of Dial without a handshake timeout
+const negotiateTimeout = 10 * time.Second
+
+// WithProtocolVersions makes Dial negotiate the protocol version with the server
+// The versions are offered in order of preference. Each version is spoken at the
+// URL path of its name, so if the server picks another version than the one of
+// the dialed path, Dial connects again at the path of the chosen version.
+// The negotiate call on the urologin object is an assumption, the univedo
+// protocol does not document it yet.
+func WithProtocolVersions(versions ...string) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.protocolVersions = versions
+	}
+}
+
+// negotiateContext bounds a negotiation by the handshake timeout or negotiateTimeout
+func negotiateContext(handshakeTimeout time.Duration) (context.Context, context.CancelFunc) {
+	if handshakeTimeout <= 0 {
+		handshakeTimeout = negotiateTimeout
+	}
+	return context.WithTimeout(context.Background(), handshakeTimeout)
+}
+
+// Negotiate asks the server which protocol version to speak and what it supports
+// It offers the versions set by WithProtocolVersions, or v1. Dial negotiates if
+// WithProtocolVersions is given, connections created with NewConnection may call
+// Negotiate before their first call. Servers without negotiation answer with an
+// error like "unknown method" and are assumed to speak v1.
+func (c *Connection) Negotiate(ctx context.Context) error {
+	c.mutex.Lock()
+	urologin := c.urologin
+	c.mutex.Unlock()
+
+	offered := c.offeredVersions()
+	versions := make([]interface{}, len(offered))
+	for i, v := range offered {
+		versions[i] = v
+	}
+	answer, err := urologin.CallROMContext(ctx, "negotiate", versions)
+	var remoteErr *RemoteError
+	if errors.As(err, &remoteErr) {
+		c.setProtocol(defaultVersion, nil)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	m, ok := answer.(map[string]interface{})
+	if !ok {
+		return errors.New("negotiate did not return a map")
+	}
+	version, _ := m["version"].(string)
+	if version == "" {
+		return errors.New("univedo: no common protocol version with the server")
+	}
+	if !isOffered(offered, version) {
+		return fmt.Errorf("univedo: server chose unsupported protocol version %q", version)
+	}
+	var capabilities []string
+	list, _ := m["capabilities"].([]interface{})
+	for _, capability := range list {
+		if s, ok := capability.(string); ok {
+			capabilities = append(capabilities, s)
+		}
+	}
+	c.setProtocol(version, capabilities)
+	return nil
+}
+
+// offeredVersions returns the versions Negotiate offers the server
+func (c *Connection) offeredVersions() []string {
+	if len(c.versions) == 0 {
+		return []string{defaultVersion}
+	}
+	return c.versions
+}
+
+func isOffered(offered []string, version string) bool {
+	for _, v := range offered {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Connection) setProtocol(version string, capabilities []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.negotiated = true
+	c.version = version
+	c.capabilities = capabilities
+}
+
+// ProtocolVersion returns the protocol version negotiated with the server
+// It is empty if the connection was not negotiated, see WithProtocolVersions.
+func (c *Connection) ProtocolVersion() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.version
+}
+
+// Capabilities returns the features the server announced during negotiation
+func (c *Connection) Capabilities() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]string{}, c.capabilities...)
+}
+
+// HasCapability reports whether the server announced the feature name
+func (c *Connection) HasCapability(name string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, capability := range c.capabilities {
+		if capability == name {
+			return true
+		}
+	}
+	return false
+}
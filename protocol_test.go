@@ -0,0 +1,131 @@
+package univedo
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/go.net/websocket"
+	"github.com/univedo/univedo-go/univedotest"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNegotiation(t *testing.T) {
+	Convey("protocol negotiation", t, func() {
+		server := univedotest.NewServer()
+		defer server.Close()
+
+		Convey("picks the version and capabilities of the server", func() {
+			server.SetCapabilities("transactions", "streaming")
+			connection, err := Dial(server.URL, WithProtocolVersions("v1"))
+			So(err, ShouldBeNil)
+			defer connection.Close()
+			So(connection.ProtocolVersion(), ShouldEqual, "v1")
+			So(connection.Capabilities(), ShouldResemble, []string{"transactions", "streaming"})
+			So(connection.HasCapability("transactions"), ShouldBeTrue)
+			So(connection.HasCapability("compression"), ShouldBeFalse)
+		})
+
+		Convey("assumes v1 for servers without negotiation", func() {
+			server.SetVersions()
+			connection, err := Dial(server.URL, WithProtocolVersions("v1"))
+			So(err, ShouldBeNil)
+			defer connection.Close()
+			So(connection.ProtocolVersion(), ShouldEqual, "v1")
+			So(connection.Capabilities(), ShouldBeEmpty)
+		})
+
+		Convey("fails without a common version", func() {
+			server.SetVersions("v2")
+			_, err := Dial(server.URL, WithProtocolVersions("v1"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("is off by default", func() {
+			connection, err := Dial(server.URL)
+			So(err, ShouldBeNil)
+			defer connection.Close()
+			So(connection.ProtocolVersion(), ShouldEqual, "")
+		})
+	})
+
+	Convey("negotiation on dial", t, func() {
+		paths := make(chan string, 2)
+		answer := func(uint64, string, []interface{}) interface{} {
+			return map[string]interface{}{"version": "v2"}
+		}
+		server := httptest.NewServer(websocket.Server{Handler: func(ws *websocket.Conn) {
+			paths <- ws.Request().URL.Path
+			serveTestPeer(NewWebsocketTransport(ws), answer)
+		}})
+		defer server.Close()
+		wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+		Convey("connects again at the path of the chosen version", func() {
+			connection, err := Dial(wsURL, WithProtocolVersions("v2", "v1"))
+			So(err, ShouldBeNil)
+			defer connection.Close()
+			So(connection.ProtocolVersion(), ShouldEqual, "v2")
+			So(<-paths, ShouldEqual, "/v1")
+			So(<-paths, ShouldEqual, "/v2")
+		})
+
+		Convey("is bounded by the handshake timeout", func() {
+			answer = func(uint64, string, []interface{}) interface{} {
+				return noAnswer
+			}
+			_, err := Dial(wsURL, WithProtocolVersions("v1"), WithHandshakeTimeout(50*time.Millisecond))
+			So(err, ShouldEqual, context.DeadlineExceeded)
+		})
+	})
+
+	Convey("negotiation rejects versions that were not offered", t, func() {
+		client, server := Pipe()
+		go serveTestPeer(server, func(uint64, string, []interface{}) interface{} {
+			return map[string]interface{}{"version": "v3"}
+		})
+		connection := NewConnection(client)
+		defer connection.Close()
+		So(connection.ProtocolVersion(), ShouldEqual, "")
+		So(connection.Negotiate(context.Background()), ShouldNotBeNil)
+	})
+}
+
+func TestPipelining(t *testing.T) {
+	Convey("pipelining", t, func() {
+		server := univedotest.NewServer()
+		defer server.Close()
+		server.SetCapabilities(PipeliningCapability)
+		uts, err := ioutil.ReadFile("test.uts")
+		So(err, ShouldBeNil)
+		connection, err := Dial(server.URL, WithProtocolVersions("v1"))
+		So(err, ShouldBeNil)
+		defer connection.Close()
+		session, err := connection.GetSession("bucket", nil)
+		So(err, ShouldBeNil)
+		So(session.ApplyUTS(string(uts)), ShouldBeNil)
+
+		Convey("calls methods on results still in flight", func() {
+			perspective, err := session.GetPerspective("cefb4ed2-4ce3-4825-8550-b68a3c142f0a")
+			So(err, ShouldBeNil)
+			res, err := perspective.CallROMAsync("query").CallROMAsync("prepare", "select * from fields_inclusive").Wait()
+			So(err, ShouldBeNil)
+			So(res, ShouldHaveSameTypeAs, &stmt{})
+
+			conn := &Conn{Connection: connection, session: session, perspective: perspective}
+			s, err := conn.Prepare("select * from fields_inclusive")
+			So(err, ShouldBeNil)
+			So(s, ShouldHaveSameTypeAs, &stmt{})
+		})
+
+		Convey("fails calls on results that are no remote objects", func() {
+			_, err := session.CallROMAsync("ping", 1).CallROMAsync("foo").Wait()
+			So(err, ShouldNotBeNil)
+			So(connection.Err(), ShouldBeNil)
+		})
+	})
+}
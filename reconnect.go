@@ -0,0 +1,262 @@
+package univedo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// ErrStaleRemoteObject is returned by calls on remote objects of a connection that was reconnected
+// Only sessions and the perspectives opened with Session.GetPerspective survive a reconnect.
+var ErrStaleRemoteObject = errors.New("univedo: remote object was invalidated by a reconnect")
+
+// A ReconnectPolicy controls how a lost connection is re-established
+// WithReconnect fills zero InitialBackoff, MaxBackoff and Multiplier from
+// DefaultReconnectPolicy, so a policy never redials without delay.
+type ReconnectPolicy struct {
+	// MaxAttempts is the number of dial attempts per disconnect, 0 means no limit
+	MaxAttempts int
+	// InitialBackoff is the delay before the first attempt
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after every failed attempt
+	Multiplier float64
+	// Jitter randomizes every delay by up to this fraction, e.g. 0.2 for ±20%
+	Jitter float64
+	// Dial opens a new transport, Dial fills it in for websocket connections
+	Dial func() (Transport, error)
+}
+
+// DefaultReconnectPolicy retries forever with exponential backoff between 100ms and 30s
+func DefaultReconnectPolicy() *ReconnectPolicy {
+	return &ReconnectPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// withDefaults returns a copy of the policy with zero delays taken from DefaultReconnectPolicy
+func (p *ReconnectPolicy) withDefaults() *ReconnectPolicy {
+	d := DefaultReconnectPolicy()
+	c := *p
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = d.InitialBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = d.MaxBackoff
+	}
+	if c.Multiplier <= 0 {
+		c.Multiplier = d.Multiplier
+	}
+	return &c
+}
+
+// backoff returns the delay before the given attempt, starting at 0
+func (p *ReconnectPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for i := 0; i < attempt && (p.MaxBackoff <= 0 || d < float64(p.MaxBackoff)); i++ {
+		d *= p.Multiplier
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// ReconnectError is the final error of a connection that could not be restored
+type ReconnectError struct {
+	// Attempts is the number of dial attempts made
+	Attempts int
+	// Err is the error of the last attempt
+	Err error
+}
+
+func (e *ReconnectError) Error() string {
+	return fmt.Sprintf("univedo: reconnect failed after %d attempts: %s", e.Attempts, e.Err.Error())
+}
+
+func (e *ReconnectError) Unwrap() error {
+	return e.Err
+}
+
+// reconnectAfter re-establishes the connection after it was lost with err
+func (c *Connection) reconnectAfter(err error) {
+	// Calls must not reach other objects with the same ids on the new transport
+	c.invalidateRemoteObjects(ErrConnectionLost)
+
+	c.mutex.Lock()
+	callbacks := append([]func(error){}, c.onDisconnect...)
+	c.mutex.Unlock()
+	for _, f := range callbacks {
+		f(err)
+	}
+
+	p := c.reconnect
+	if p.Dial == nil {
+		c.finish(&ReconnectError{Err: errors.New("no dial function in reconnect policy")})
+		return
+	}
+
+	for attempt := 0; p.MaxAttempts == 0 || attempt < p.MaxAttempts; attempt++ {
+		select {
+		case <-time.After(p.backoff(attempt)):
+		case <-c.closing:
+			c.finish(ErrConnectionClosed)
+			return
+		}
+
+		var t Transport
+		t, err = p.Dial()
+		if err != nil {
+			continue
+		}
+
+		err = c.restore(t)
+		if errors.Is(err, ErrConnectionLost) {
+			// The new transport is gone already, its reader reconnects again
+			return
+		}
+		if err != nil {
+			c.finish(&ReconnectError{Attempts: attempt + 1, Err: err})
+			t.Close()
+			return
+		}
+
+		c.metrics.reconnected()
+		c.mutex.Lock()
+		callbacks := append([]func(){}, c.onReconnect...)
+		c.mutex.Unlock()
+		for _, f := range callbacks {
+			f()
+		}
+		return
+	}
+
+	c.finish(&ReconnectError{Attempts: p.MaxAttempts, Err: err})
+}
+
+// restore starts using t and reopens all sessions and their perspectives
+func (c *Connection) restore(t Transport) error {
+	c.remoteObjectsMutex.Lock()
+	old := c.remoteObjects
+	c.remoteObjects = make(map[uint64]RemoteObject)
+	for id, ro := range old {
+		// Exported objects live on the client and stay available
+		if b, ok := ro.(*BasicRemoteObject); ok && b.handler != nil {
+			b.rebind(id)
+			c.remoteObjects[id] = ro
+		}
+	}
+	c.remoteObjectsMutex.Unlock()
+
+	t = c.attach(t)
+	go c.run(t)
+
+	c.mutex.Lock()
+	sessions := append([]*Session{}, c.sessions...)
+	negotiated, version := c.negotiated, c.version
+	c.mutex.Unlock()
+
+	// The server may have been upgraded in the meantime, but the transport speaks the old version
+	if negotiated {
+		ctx, cancel := negotiateContext(0)
+		err := c.Negotiate(ctx)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if v := c.ProtocolVersion(); v != version {
+			return fmt.Errorf("univedo: server switched protocol version from %q to %q", version, v)
+		}
+	}
+
+	restored := map[RemoteObject]bool{}
+	for _, s := range sessions {
+		reopened, err := c.reopenSession(context.Background(), s, false)
+		if err != nil {
+			return err
+		}
+		for _, ro := range reopened {
+			restored[ro] = true
+		}
+	}
+
+	for _, ro := range old {
+		if !restored[ro] {
+			if i, ok := ro.(interface {
+				invalidate(error)
+			}); ok {
+				i.invalidate(ErrStaleRemoteObject)
+			}
+		}
+	}
+	return nil
+}
+
+// reopenSession opens s and its perspectives again with fresh credentials
+// It returns the objects that were rebound to their new ids. Their old ids stay
+// known if keepOld is set, see replaceRemoteObject.
+func (c *Connection) reopenSession(ctx context.Context, s *Session, keepOld bool) ([]RemoteObject, error) {
+	creds, err := s.provider.Credentials(ctx, s.bucket)
+	if err != nil {
+		return nil, err
+	}
+	fresh, err := c.getSession(s.bucket, creds)
+	if err != nil {
+		return nil, err
+	}
+
+	s.perspectivesMutex.Lock()
+	perspectives := append([]openPerspective{}, s.perspectives...)
+	s.perspectivesMutex.Unlock()
+	var reopened []RemoteObject
+	for _, p := range perspectives {
+		freshPerspective, err := getRoFromROM(fresh, "getPerspective", p.name)
+		if err != nil {
+			return nil, err
+		}
+		c.replaceRemoteObject(freshPerspective, p.ro, keepOld)
+		reopened = append(reopened, p.ro)
+	}
+
+	c.replaceRemoteObject(fresh, s, keepOld)
+	return append(reopened, s), nil
+}
+
+// replaceRemoteObject binds old to the id of fresh, so that callers keep using old
+// With keepOld the old id stays mapped to old as well, so that answers to calls sent
+// with it are still received, until it is released with retireRemoteObject.
+func (c *Connection) replaceRemoteObject(fresh, old RemoteObject, keepOld bool) {
+	id := fresh.ID()
+	oldID := old.ID()
+	if r, ok := old.(interface {
+		rebind(uint64)
+	}); ok {
+		r.rebind(id)
+	}
+	c.remoteObjectsMutex.Lock()
+	if c.remoteObjects[oldID] == old && !keepOld {
+		delete(c.remoteObjects, oldID)
+	}
+	c.remoteObjects[id] = old
+	c.remoteObjectsMutex.Unlock()
+}
+
+// retireRemoteObject deletes the former id of ro on the server and forgets it
+func (c *Connection) retireRemoteObject(id uint64, ro RemoteObject) {
+	// The server drops the object together with the connection if sending fails
+	c.sendMessage(id, uint64(romDelete))
+	c.remoteObjectsMutex.Lock()
+	if c.remoteObjects[id] == ro {
+		delete(c.remoteObjects, id)
+	}
+	c.remoteObjectsMutex.Unlock()
+}
@@ -0,0 +1,177 @@
+package univedo
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newTestServer returns a dial function for pipes served by peers handing out ids from base
+func newTestServer(base *uint64) func() (Transport, error) {
+	return func() (Transport, error) {
+		client, server := Pipe()
+		b := *base
+		go serveTestPeer(server, func(roID uint64, method string, args []interface{}) interface{} {
+			switch method {
+			case "getSession":
+				return testRORef{"com.univedo.session", b + 1}
+			case "getPerspective":
+				return testRORef{"com.univedo.perspective", b + 2}
+			case "query":
+				return testRORef{"com.univedo.query", b + 3}
+			case "ping":
+				return roID
+			}
+			return nil
+		})
+		*base += 10
+		return client, nil
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	Convey("reconnects", t, func() {
+		var base uint64
+		dial := newTestServer(&base)
+		policy := &ReconnectPolicy{InitialBackoff: time.Millisecond, MaxAttempts: 3, Dial: func() (Transport, error) {
+			return dial()
+		}}
+
+		Convey("compute backoff", func() {
+			p := &ReconnectPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 2}
+			So(p.backoff(0), ShouldEqual, time.Second)
+			So(p.backoff(2), ShouldEqual, 4*time.Second)
+			So(p.backoff(10), ShouldEqual, 5*time.Second)
+			p.Jitter = 0.5
+			So(p.backoff(0), ShouldBeBetweenOrEqual, 500*time.Millisecond, 1500*time.Millisecond)
+		})
+
+		Convey("fill zero delays from the default policy", func() {
+			cfg := newDialConfig([]DialOption{WithReconnect(&ReconnectPolicy{MaxAttempts: 3})})
+			So(cfg.reconnect.MaxAttempts, ShouldEqual, 3)
+			So(cfg.reconnect.backoff(0), ShouldEqual, 100*time.Millisecond)
+			So(cfg.reconnect.backoff(1), ShouldEqual, 200*time.Millisecond)
+			So(cfg.reconnect.backoff(20), ShouldEqual, 30*time.Second)
+
+			cfg = newDialConfig([]DialOption{WithReconnect(policy)})
+			So(cfg.reconnect.backoff(0), ShouldEqual, time.Millisecond)
+			So(policy.Multiplier, ShouldEqual, 0)
+		})
+
+		Convey("restore sessions and perspectives", func() {
+			first, _ := dial()
+			connection := NewConnection(first, WithReconnect(policy))
+			reconnected := make(chan struct{})
+			var disconnectErr error
+			connection.OnDisconnect(func(err error) { disconnectErr = err })
+			connection.OnReconnect(func() { close(reconnected) })
+
+			session, err := connection.GetSession("bucket", nil)
+			So(err, ShouldBeNil)
+			perspective, err := session.GetPerspective("perspective")
+			So(err, ShouldBeNil)
+			query, err := getRoFromROM(perspective, "query")
+			So(err, ShouldBeNil)
+			id, err := session.Ping(nil)
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, 1)
+
+			first.Close()
+			<-reconnected
+			So(disconnectErr, ShouldNotBeNil)
+
+			id, err = session.Ping(nil)
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, 11)
+			So(perspective.ID(), ShouldEqual, 12)
+			_, err = query.CallROM("prepare")
+			So(errors.Is(err, ErrStaleRemoteObject), ShouldBeTrue)
+			connection.Close()
+		})
+
+		Convey("stop restoring closed sessions and perspectives", func() {
+			first, _ := dial()
+			connection := NewConnection(first, WithReconnect(policy))
+			defer connection.Close()
+			reconnected := make(chan struct{})
+			connection.OnReconnect(func() { close(reconnected) })
+
+			session, err := connection.GetSession("bucket", nil)
+			So(err, ShouldBeNil)
+			perspective, err := session.GetPerspective("perspective")
+			So(err, ShouldBeNil)
+			So(session.ClosePerspective(perspective), ShouldBeNil)
+			So(session.perspectives, ShouldBeEmpty)
+			_, err = perspective.CallROM("query")
+			So(err, ShouldEqual, ErrRemoteObjectReleased)
+
+			So(session.Close(), ShouldBeNil)
+			So(connection.sessions, ShouldBeEmpty)
+			So(connection.remoteObject(1), ShouldBeNil)
+
+			first.Close()
+			<-reconnected
+			_, err = session.Ping(nil)
+			So(err, ShouldEqual, ErrRemoteObjectReleased)
+		})
+
+		Convey("forget sessions deleted by the server", func() {
+			client, server := Pipe()
+			go serveTestPeer(server, func(uint64, string, []interface{}) interface{} {
+				return testRORef{"com.univedo.session", 1}
+			})
+			connection := NewConnection(client)
+			defer connection.Close()
+			session, err := connection.GetSession("bucket", nil)
+			So(err, ShouldBeNil)
+
+			m := &message{buffer: &bytes.Buffer{}}
+			m.send(uint64(1))
+			m.send(uint64(romDelete))
+			So(server.SendFrame(m.buffer.Bytes()), ShouldBeNil)
+			for err != ErrRemoteObjectReleased {
+				time.Sleep(time.Millisecond)
+				_, err = session.Ping(nil)
+			}
+			connection.mutex.Lock()
+			So(connection.sessions, ShouldBeEmpty)
+			connection.mutex.Unlock()
+			So(connection.remoteObject(1), ShouldBeNil)
+		})
+
+		Convey("fail calls once reconnecting gives up", func() {
+			first, _ := dial()
+			policy.Dial = func() (Transport, error) {
+				return nil, errors.New("unreachable")
+			}
+			connection := NewConnection(first, WithReconnect(policy))
+			session, err := connection.GetSession("bucket", nil)
+			So(err, ShouldBeNil)
+			first.Close()
+
+			var reconnectErr *ReconnectError
+			for !errors.As(err, &reconnectErr) {
+				time.Sleep(time.Millisecond)
+				_, err = session.Ping(nil)
+			}
+			So(reconnectErr.Attempts, ShouldEqual, 3)
+			So(reconnectErr.Err.Error(), ShouldEqual, "unreachable")
+		})
+
+		Convey("do not reconnect closed connections", func() {
+			first, _ := dial()
+			connection := NewConnection(first, WithReconnect(policy))
+			session, err := connection.GetSession("bucket", nil)
+			So(err, ShouldBeNil)
+			connection.Close()
+			for err == nil || err == ErrConnectionLost {
+				time.Sleep(time.Millisecond)
+				_, err = session.Ping(nil)
+			}
+			So(err, ShouldEqual, ErrConnectionClosed)
+		})
+	})
+}
@@ -0,0 +1,237 @@
+package univedo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Directions of recorded frames
+const (
+	recordSent     = "send"
+	recordReceived = "recv"
+)
+
+// A recorded frame
+type frameRecord struct {
+	direction string
+	time      time.Time
+	frame     []byte
+}
+
+// recordingTransport writes every frame passing through it to a recording
+type recordingTransport struct {
+	Transport
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// NewRecordingTransport wraps t and writes every frame sent and received to w
+// The recording is a sequence of CBOR arrays [direction, time, frame], where
+// direction is "send" or "recv". Use NewReplayTransport to replay it.
+func NewRecordingTransport(t Transport, w io.Writer) Transport {
+	return &recordingTransport{Transport: t, w: w}
+}
+
+// WithRecorder records all frames of the connection to w, see NewRecordingTransport
+func WithRecorder(w io.Writer) DialOption {
+	return func(cfg *dialConfig) {
+		mutex := new(sync.Mutex)
+		cfg.wrapTransport = append(cfg.wrapTransport, func(t Transport) Transport {
+			return &recordingTransport{Transport: t, w: &lockedWriter{w: w, mutex: mutex}}
+		})
+	}
+}
+
+// lockedWriter serializes writes of several transports recording to the same writer
+type lockedWriter struct {
+	w     io.Writer
+	mutex *sync.Mutex
+}
+
+func (l *lockedWriter) Write(b []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.w.Write(b)
+}
+
+func (t *recordingTransport) record(direction string, frame []byte) error {
+	m := &message{buffer: &bytes.Buffer{}}
+	err := m.send([]interface{}{direction, time.Now().UTC(), frame})
+	if err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	_, err = t.w.Write(m.buffer.Bytes())
+	return err
+}
+
+// SendFrame records the frame before sending it, the answer may be received before the send returns
+func (t *recordingTransport) SendFrame(frame []byte) error {
+	if err := t.record(recordSent, frame); err != nil {
+		return err
+	}
+	return t.Transport.SendFrame(frame)
+}
+
+func (t *recordingTransport) ReceiveFrame() ([]byte, error) {
+	frame, err := t.Transport.ReceiveFrame()
+	if err != nil {
+		return nil, err
+	}
+	return frame, t.record(recordReceived, frame)
+}
+
+// readRecording parses a recording written by a recording transport
+func readRecording(r io.Reader) ([]frameRecord, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	m := &message{buffer: bytes.NewBuffer(b)}
+	var records []frameRecord
+	for !m.empty() {
+		v, err := m.read()
+		if err != nil {
+			return nil, err
+		}
+		list, ok := v.([]interface{})
+		if !ok || len(list) != 3 {
+			return nil, errors.New("invalid record in recording")
+		}
+		direction, _ := list[0].(string)
+		t, _ := list[1].(time.Time)
+		frame, ok := list[2].([]byte)
+		if !ok || (direction != recordSent && direction != recordReceived) {
+			return nil, errors.New("invalid record in recording")
+		}
+		records = append(records, frameRecord{direction: direction, time: t, frame: frame})
+	}
+	return records, nil
+}
+
+// A recorded request together with the frames received after it
+type replayExchange struct {
+	request   []interface{}
+	responses [][]byte
+	used      bool
+}
+
+// ErrReplayMismatch is returned when a replayed connection sends a frame that was not recorded
+var ErrReplayMismatch = errors.New("univedo: frame not found in recording")
+
+// replayTransport answers requests with the frames recorded after matching requests
+type replayTransport struct {
+	mutex     sync.Mutex
+	exchanges []*replayExchange
+
+	frames    chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewReplayTransport returns a transport replaying a recording made with NewRecordingTransport
+// Every frame sent is matched against the recorded requests by remote object id,
+// opcode, call id and method or notification name, and the frames received after
+// the first unused matching request are delivered in their recorded order.
+func NewReplayTransport(r io.Reader) (Transport, error) {
+	records, err := readRecording(r)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &replayTransport{frames: make(chan []byte, len(records)), closed: make(chan struct{})}
+	var current *replayExchange
+	for _, rec := range records {
+		if rec.direction == recordReceived {
+			if current == nil {
+				// Frames the server sent before any request
+				t.frames <- rec.frame
+			} else {
+				current.responses = append(current.responses, rec.frame)
+			}
+			continue
+		}
+		request, err := replayKey(rec.frame)
+		if err != nil {
+			return nil, err
+		}
+		current = &replayExchange{request: request}
+		t.exchanges = append(t.exchanges, current)
+	}
+	return t, nil
+}
+
+// replayKey decodes the parts of a frame that identify a request
+func replayKey(frame []byte) ([]interface{}, error) {
+	m := &message{buffer: bytes.NewBuffer(frame), createRO: func(id uint64, name string) (interface{}, error) {
+		return []interface{}{name, id}, nil
+	}}
+	var key []interface{}
+	// Remote object id, opcode and call id or notification name, then the method name of calls
+	for i := 0; i < 4 && !m.empty(); i++ {
+		v, err := m.read()
+		if err != nil {
+			return nil, err
+		}
+		key = append(key, v)
+	}
+	if len(key) > 1 && key[1] != uint64(romCall) && len(key) > 3 {
+		key = key[:3]
+	}
+	return key, nil
+}
+
+func (t *replayTransport) SendFrame(frame []byte) error {
+	select {
+	case <-t.closed:
+		return io.ErrClosedPipe
+	default:
+	}
+
+	key, err := replayKey(frame)
+	if err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	var exchange *replayExchange
+	for _, e := range t.exchanges {
+		if !e.used && reflect.DeepEqual(e.request, key) {
+			exchange = e
+			e.used = true
+			break
+		}
+	}
+	t.mutex.Unlock()
+	if exchange == nil {
+		return fmt.Errorf("%w: %v", ErrReplayMismatch, key)
+	}
+
+	for _, f := range exchange.responses {
+		t.frames <- f
+	}
+	return nil
+}
+
+func (t *replayTransport) ReceiveFrame() ([]byte, error) {
+	select {
+	case f := <-t.frames:
+		return f, nil
+	case <-t.closed:
+		return nil, io.EOF
+	}
+}
+
+func (t *replayTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+	})
+	return nil
+}
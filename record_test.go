@@ -0,0 +1,143 @@
+package univedo
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecordReplay(t *testing.T) {
+	Convey("record and replay", t, func() {
+		var recording bytes.Buffer
+		connection, err := Dial(testURL, WithRecorder(&recording))
+		So(err, ShouldBeNil)
+		session, err := connection.GetSession("79CB0F8E-3D90-484A-9A88-B13E97FA65D9", map[string]interface{}{"username": "marvin"})
+		So(err, ShouldBeNil)
+		pong, err := session.Ping("foo")
+		So(err, ShouldBeNil)
+		So(pong, ShouldEqual, "foo")
+		connection.Close()
+
+		Convey("writes direction tagged frames", func() {
+			records, err := readRecording(bytes.NewReader(recording.Bytes()))
+			So(err, ShouldBeNil)
+			So(len(records), ShouldEqual, 4)
+			So(records[0].direction, ShouldEqual, recordSent)
+			So(records[1].direction, ShouldEqual, recordReceived)
+			So(records[0].time.IsZero(), ShouldBeFalse)
+		})
+
+		Convey("replays the recorded answers", func() {
+			replay, err := NewReplayTransport(bytes.NewReader(recording.Bytes()))
+			So(err, ShouldBeNil)
+			connection := NewConnection(replay)
+			defer connection.Close()
+			session, err := connection.GetSession("79CB0F8E-3D90-484A-9A88-B13E97FA65D9", map[string]interface{}{"username": "marvin"})
+			So(err, ShouldBeNil)
+			pong, err := session.Ping("foo")
+			So(err, ShouldBeNil)
+			So(pong, ShouldEqual, "foo")
+
+			Convey("fails requests that were not recorded", func() {
+				_, err := session.Ping("foo")
+				So(errors.Is(err, ErrReplayMismatch), ShouldBeTrue)
+			})
+		})
+
+		Convey("rejects invalid recordings", func() {
+			_, err := NewReplayTransport(bytes.NewReader([]byte{0x01}))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// answeringTransport answers every call while it is being sent
+// SendFrame only returns after the connection asked for the frame following the answer.
+type answeringTransport struct {
+	frames    chan []byte
+	next      chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+	delivered bool
+}
+
+func newAnsweringTransport() *answeringTransport {
+	return &answeringTransport{frames: make(chan []byte), next: make(chan struct{}), closed: make(chan struct{})}
+}
+
+func (t *answeringTransport) SendFrame(frame []byte) error {
+	msg := &message{buffer: bytes.NewBuffer(frame)}
+	var data []interface{}
+	for !msg.empty() {
+		v, err := msg.read()
+		if err != nil {
+			return err
+		}
+		data = append(data, v)
+	}
+	reply := &message{buffer: &bytes.Buffer{}}
+	for _, v := range []interface{}{data[0], uint64(romAnswer), data[2], uint64(0), data[4]} {
+		reply.send(v)
+	}
+	select {
+	case t.frames <- reply.buffer.Bytes():
+	case <-t.closed:
+		return io.ErrClosedPipe
+	}
+	select {
+	case <-t.next:
+	case <-t.closed:
+	}
+	return nil
+}
+
+func (t *answeringTransport) ReceiveFrame() ([]byte, error) {
+	if t.delivered {
+		select {
+		case t.next <- struct{}{}:
+		case <-t.closed:
+			return nil, io.EOF
+		}
+	}
+	select {
+	case f := <-t.frames:
+		t.delivered = true
+		return f, nil
+	case <-t.closed:
+		return nil, io.EOF
+	}
+}
+
+func (t *answeringTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+	})
+	return nil
+}
+
+func TestRecordOrder(t *testing.T) {
+	Convey("record calls before their answers", t, func() {
+		var recording bytes.Buffer
+		connection := NewConnection(newAnsweringTransport(), WithRecorder(&recording))
+		for i := 0; i < 3; i++ {
+			_, err := connection.urologin.CallROM("ping", "foo")
+			So(err, ShouldBeNil)
+		}
+		connection.Close()
+
+		records, err := readRecording(bytes.NewReader(recording.Bytes()))
+		So(err, ShouldBeNil)
+		So(len(records), ShouldEqual, 6)
+		for i, r := range records {
+			if i%2 == 0 {
+				So(r.direction, ShouldEqual, recordSent)
+			} else {
+				So(r.direction, ShouldEqual, recordReceived)
+			}
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package univedo
+
+import "sync"
+
+// A Registry maps remote object names to factories creating their Go values
+type Registry struct {
+	parent *Registry
+
+	mutex     sync.RWMutex
+	factories map[string]func(id uint64, session Sender) RemoteObject
+}
+
+// registeredRemoteObjects is the global registry used by all connections
+var registeredRemoteObjects = &Registry{factories: make(map[string]func(id uint64, s Sender) RemoteObject)}
+
+// RegisterRemoteObject adds a remote object factory for a RO name to the global registry
+func RegisterRemoteObject(name string, factory func(id uint64, session Sender) RemoteObject) {
+	registeredRemoteObjects.Register(name, factory)
+}
+
+// NewRegistry returns an empty registry falling back to the global registry
+func NewRegistry() *Registry {
+	return &Registry{
+		parent:    registeredRemoteObjects,
+		factories: make(map[string]func(id uint64, s Sender) RemoteObject),
+	}
+}
+
+// Register adds a remote object factory for a RO name, replacing any previous one
+func (r *Registry) Register(name string, factory func(id uint64, session Sender) RemoteObject) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.factories[name] = factory
+}
+
+// factory returns the factory for a RO name, or nil if neither r nor its parents know it
+func (r *Registry) factory(name string) func(id uint64, session Sender) RemoteObject {
+	for ; r != nil; r = r.parent {
+		r.mutex.RLock()
+		f := r.factories[name]
+		r.mutex.RUnlock()
+		if f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// WithRegistry makes the connection create remote objects from r instead of the global registry
+func WithRegistry(r *Registry) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.registry = r
+	}
+}
@@ -0,0 +1,69 @@
+package univedo
+
+import (
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testThing struct {
+	*BasicRemoteObject
+	tenant string
+}
+
+func testThingFactory(tenant string) func(id uint64, s Sender) RemoteObject {
+	return func(id uint64, s Sender) RemoteObject {
+		return &testThing{NewBasicRO(id, s), tenant}
+	}
+}
+
+// dialThing returns the remote object com.example.thing received over a connection with opts
+func dialThing(opts ...DialOption) interface{} {
+	client, server := Pipe()
+	go serveTestPeer(server, func(roID uint64, method string, args []interface{}) interface{} {
+		return testRORef{"com.example.thing", 1}
+	})
+	connection := NewConnection(client, opts...)
+	defer connection.Close()
+	thing, err := connection.remoteObject(0).CallROM("getThing")
+	So(err, ShouldBeNil)
+	return thing
+}
+
+func TestRegistry(t *testing.T) {
+	Convey("registries", t, func() {
+		Convey("map names per connection", func() {
+			a := NewRegistry()
+			a.Register("com.example.thing", testThingFactory("a"))
+			b := NewRegistry()
+			b.Register("com.example.thing", testThingFactory("b"))
+
+			So(dialThing(WithRegistry(a)).(*testThing).tenant, ShouldEqual, "a")
+			So(dialThing(WithRegistry(b)).(*testThing).tenant, ShouldEqual, "b")
+			_, ok := dialThing().(*BasicRemoteObject)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("fall back to the global registry", func() {
+			r := NewRegistry()
+			So(r.factory("com.univedo.session"), ShouldNotBeNil)
+			So(r.factory("com.example.unknown"), ShouldBeNil)
+		})
+
+		Convey("register concurrently", func() {
+			r := NewRegistry()
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					r.Register("com.example.thing", testThingFactory("x"))
+					r.factory("com.example.thing")
+				}()
+			}
+			wg.Wait()
+			So(r.factory("com.example.thing"), ShouldNotBeNil)
+		})
+	})
+}
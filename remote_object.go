@@ -1,7 +1,12 @@
 package univedo
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"log"
+	"sync"
 )
 
 const (
@@ -11,62 +16,594 @@ const (
 	romDelete        = 4
 )
 
-// A remote method result can either be an error or a message
-type romResult struct {
-	err   error
-	value interface{}
+// A call waiting for its answer
+type pendingCall struct {
+	method string
+	future *Future
+
+	// id is the call id the call was last sent with and roID the remote object id it
+	// was sent to, both guarded by the callsMutex of its remote object
+	id   uint64
+	roID uint64
+
+	// args, ctx and generation are kept to send the call again after renewing expired credentials
+	args       []interface{}
+	ctx        context.Context
+	generation uint64
+	renewed    bool
+
+	// promise is the call whose result a pipelined call runs on
+	promise *pendingCall
+
+	// abandoned calls were given up by the caller, they wait for their answer only
+	// to release remote objects it returns
+	abandoned bool
+}
+
+// RemoteError is returned when the server answers a remote method call with an error
+type RemoteError struct {
+	ObjectID   uint64
+	ObjectName string
+	Method     string
+	CallID     uint64
+	Message    string
+
+	// Code and Details are only set if the server sent a structured error
+	Code    interface{}
+	Details interface{}
+
+	// expired is set if Code is the credentials expired code of the connection
+	expired bool
+}
+
+func (e *RemoteError) Error() string {
+	object := fmt.Sprintf("remote object %d", e.ObjectID)
+	if e.ObjectName != "" {
+		object = fmt.Sprintf("%s (%d)", e.ObjectName, e.ObjectID)
+	}
+	return fmt.Sprintf("univedo: %s on %s failed: %s", e.Method, object, e.Message)
+}
+
+// newRemoteError builds a RemoteError from the error payload of an answer
+func newRemoteError(ro *BasicRemoteObject, callID uint64, method string, payload interface{}) *RemoteError {
+	e := &RemoteError{ObjectID: ro.ID(), ObjectName: ro.name, Method: method, CallID: callID}
+	switch p := payload.(type) {
+	case string:
+		e.Message = p
+	case map[string]interface{}:
+		e.Code = p["code"]
+		e.Details = p
+		if msg, ok := p["message"].(string); ok {
+			e.Message = msg
+		} else {
+			e.Message = fmt.Sprint(p)
+		}
+	default:
+		e.Details = p
+		e.Message = fmt.Sprint(p)
+	}
+
+	code := CredentialsExpiredCode
+	if c, ok := ro.session.(interface {
+		expiredCode() string
+	}); ok {
+		code = c.expiredCode()
+	}
+	e.expired = e.Code == code
+	return e
 }
 
-type sender interface {
+// A Sender sends the messages of remote objects, connections and sessions are senders
+// Factories registered for remote object names pass it on to NewBasicRO.
+type Sender interface {
 	sendMessage(...interface{}) error
 }
 
 // RemoteObject provides methods for calling remote methods
 type RemoteObject interface {
+	ID() uint64
+	Name() string
 	CallROM(string, ...interface{}) (interface{}, error)
+	CallROMContext(context.Context, string, ...interface{}) (interface{}, error)
+	CallROMAsync(string, ...interface{}) *Future
 	SendNotification(string, ...interface{}) error
 	receive(msg []interface{}) error
 }
 
+// A subscription to a notification on a remote object
+type subscription struct {
+	handler func([]interface{})
+}
+
+// subscribeChanSize is the number of notifications a channel from SubscribeChan buffers
+const subscribeChanSize = 16
+
 // BasicRemoteObject can be used as a simple remote object without convenience wrappers
 type BasicRemoteObject struct {
-	id            uint64
-	session       sender
-	callID        uint64
-	callResults   map[uint64]chan romResult
+	id      uint64
+	name    string
+	session Sender
+
+	// callsMutex guards id, stale, callID, calls, retiring and owner
+	callsMutex sync.Mutex
+	stale      error
+	callID     uint64
+	calls      map[uint64]*pendingCall
+	// retiring maps former ids with calls in flight to the functions releasing them
+	retiring map[uint64]func()
+	// owner is the session the remote object was opened through, it renews expired credentials
+	owner *Session
+
+	// subscriptionsMutex guards subscriptions, unhandled and the delivery queue
+	subscriptionsMutex sync.Mutex
+	subscriptions      map[string][]*subscription
+	unhandled          func(name string, args []interface{})
+	deliveries         []func()
+	delivering         bool
+
+	// handler answers calls from the server, only set for exported objects
+	handler CallHandler
+
+	// Notifications maps notification names to handlers used when nobody subscribed
+	// The map must not be modified while notifications arrive.
+	//
+	// Deprecated: Use Subscribe, which is safe for concurrent use.
 	Notifications map[string]func([]interface{})
 }
 
 // NewBasicRO creates a new remote object
-func NewBasicRO(id uint64, session sender) *BasicRemoteObject {
-	m := make(map[uint64]chan romResult)
-	n := make(map[string]func([]interface{}))
-	return &BasicRemoteObject{id: id, session: session, callResults: m, Notifications: n}
+func NewBasicRO(id uint64, session Sender) *BasicRemoteObject {
+	m := make(map[uint64]*pendingCall)
+	n := make(map[string][]*subscription)
+	return &BasicRemoteObject{id: id, session: session, calls: m, subscriptions: n, Notifications: make(map[string]func([]interface{}))}
+}
+
+// Subscribe registers a handler for notifications with the given name
+// Several handlers may be subscribed to the same name. Handlers are called one
+// after another in the order the notifications arrived, on a goroutine separate
+// from the connection. The returned function removes the subscription again.
+func (ro *BasicRemoteObject) Subscribe(name string, handler func([]interface{})) (unsubscribe func()) {
+	sub := &subscription{handler: handler}
+
+	ro.subscriptionsMutex.Lock()
+	ro.subscriptions[name] = append(ro.subscriptions[name], sub)
+	ro.subscriptionsMutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ro.subscriptionsMutex.Lock()
+			defer ro.subscriptionsMutex.Unlock()
+			subs := ro.subscriptions[name]
+			for i, s := range subs {
+				if s == sub {
+					subs = append(subs[:i:i], subs[i+1:]...)
+					break
+				}
+			}
+			if len(subs) == 0 {
+				delete(ro.subscriptions, name)
+			} else {
+				ro.subscriptions[name] = subs
+			}
+		})
+	}
+}
+
+// SubscribeChan returns a channel receiving the arguments of every notification with the given name
+// Notifications arriving while the channel is full are logged and dropped, so that
+// a slow reader does not hold up the other notifications of the remote object. The
+// returned function removes the subscription and closes the channel.
+func (ro *BasicRemoteObject) SubscribeChan(name string) (<-chan []interface{}, func()) {
+	c := make(chan []interface{}, subscribeChanSize)
+
+	// mutex guards closed, so that no notification is sent on the closed channel
+	var mutex sync.Mutex
+	closed := false
+	unsubscribe := ro.Subscribe(name, func(args []interface{}) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case c <- args:
+		default:
+			log.Printf("univedo: dropped notification %s on remote object %d, channel is full", name, ro.ID())
+		}
+	})
+
+	return c, func() {
+		unsubscribe()
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !closed {
+			closed = true
+			close(c)
+		}
+	}
+}
+
+// SetUnhandledNotificationHandler sets the fallback for notifications nobody subscribed to
+// By default unhandled notifications are logged and otherwise ignored.
+func (ro *BasicRemoteObject) SetUnhandledNotificationHandler(handler func(name string, args []interface{})) {
+	ro.subscriptionsMutex.Lock()
+	ro.unhandled = handler
+	ro.subscriptionsMutex.Unlock()
+}
+
+// notify dispatches a notification to all subscribers or the unhandled fallback
+func (ro *BasicRemoteObject) notify(name string, args []interface{}) {
+	ro.subscriptionsMutex.Lock()
+	subs := ro.subscriptions[name]
+	unhandled := ro.unhandled
+	ro.subscriptionsMutex.Unlock()
+
+	if len(subs) == 0 {
+		if handler := ro.Notifications[name]; handler != nil {
+			ro.deliver(func() { handler(args) })
+			return
+		}
+		if unhandled == nil {
+			log.Printf("univedo: unhandled notification %s on remote object %d", name, ro.ID())
+			return
+		}
+		ro.deliver(func() { unhandled(name, args) })
+		return
+	}
+
+	ro.deliver(func() {
+		for _, s := range subs {
+			s.handler(args)
+		}
+	})
+}
+
+// deliver queues f to be run after all previously queued deliveries
+func (ro *BasicRemoteObject) deliver(f func()) {
+	ro.subscriptionsMutex.Lock()
+	defer ro.subscriptionsMutex.Unlock()
+	ro.deliveries = append(ro.deliveries, f)
+	if !ro.delivering {
+		ro.delivering = true
+		go ro.runDeliveries()
+	}
+}
+
+// delivered returns a channel closed once all notifications received so far were delivered
+func (ro *BasicRemoteObject) delivered() <-chan struct{} {
+	c := make(chan struct{})
+	ro.deliver(func() { close(c) })
+	return c
+}
+
+func (ro *BasicRemoteObject) runDeliveries() {
+	for {
+		ro.subscriptionsMutex.Lock()
+		if len(ro.deliveries) == 0 {
+			ro.delivering = false
+			ro.subscriptionsMutex.Unlock()
+			return
+		}
+		f := ro.deliveries[0]
+		ro.deliveries = ro.deliveries[1:]
+		ro.subscriptionsMutex.Unlock()
+		f()
+	}
+}
+
+// ID returns the id of the remote object on its connection
+func (ro *BasicRemoteObject) ID() uint64 {
+	ro.callsMutex.Lock()
+	defer ro.callsMutex.Unlock()
+	return ro.id
+}
+
+// Name returns the name the remote object was registered with, if any
+func (ro *BasicRemoteObject) Name() string {
+	return ro.name
+}
+
+// interceptor returns the interceptor of the connection the remote object belongs to
+func (ro *BasicRemoteObject) interceptor() Interceptor {
+	if p, ok := ro.session.(interceptorProvider); ok {
+		return p.interceptor()
+	}
+	return nil
 }
 
 // CallROM calls a method on the remote object and returns its result
 func (ro *BasicRemoteObject) CallROM(name string, args ...interface{}) (interface{}, error) {
-	c := make(chan romResult)
-	ro.callResults[ro.callID] = c
-	defer delete(ro.callResults, ro.callID)
+	return ro.CallROMContext(context.Background(), name, args...)
+}
 
-	err := ro.session.sendMessage(ro.id, uint64(romCall), ro.callID, name, args)
-	if err != nil {
+// CallROMContext calls a method on the remote object and returns its result
+// If ctx is done before the answer arrives, ctx.Err() is returned. A remote
+// object the server answers with afterwards is released again.
+func (ro *BasicRemoteObject) CallROMContext(ctx context.Context, name string, args ...interface{}) (interface{}, error) {
+	if i := ro.interceptor(); i != nil {
+		return i(ctx, ro, name, args, ro.invoke)
+	}
+	return ro.invoke(ctx, ro, name, args)
+}
+
+// CallROMAsync calls a method on the remote object without waiting for its result
+// The call is sent before CallROMAsync returns, so calls keep their order.
+func (ro *BasicRemoteObject) CallROMAsync(name string, args ...interface{}) *Future {
+	i := ro.interceptor()
+	if i == nil {
+		return ro.startCall(context.Background(), name, args)
+	}
+
+	// The interceptors wait for the answer on their own goroutine
+	f := newFuture()
+	sent := make(chan struct{})
+	var sentOnce sync.Once
+	invoke := func(ctx context.Context, _ RemoteObject, name string, args []interface{}) (interface{}, error) {
+		if err := ctx.Err(); err != nil {
+			sentOnce.Do(func() { close(sent) })
+			return nil, err
+		}
+		call := ro.startCall(ctx, name, args)
+		sentOnce.Do(func() { close(sent) })
+		return await(ctx, call)
+	}
+	go func() {
+		f.resolve(i(context.Background(), ro, name, args, invoke))
+	}()
+
+	// Interceptors may also answer without calling invoke
+	select {
+	case <-sent:
+	case <-f.Done():
+	}
+	return f
+}
+
+// invoke is the Invoker performing calls on the remote object
+func (ro *BasicRemoteObject) invoke(ctx context.Context, _ RemoteObject, name string, args []interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
+	return await(ctx, ro.startCall(ctx, name, args))
+}
+
+// await waits for the answer to a call, abandoning the call when ctx is done
+func await(ctx context.Context, f *Future) (interface{}, error) {
+	select {
+	case <-ctx.Done():
+		f.abandon(ctx.Err())
+		return nil, ctx.Err()
+	case <-f.Done():
+		return f.Wait()
+	}
+}
+
+// startCall sends a call to the remote object and returns the future for its answer
+func (ro *BasicRemoteObject) startCall(ctx context.Context, name string, args []interface{}) *Future {
+	call := &pendingCall{method: name, future: newFuture(), args: args, ctx: ctx}
+	if s := ro.getOwner(); s != nil {
+		call.generation = s.credentialsGeneration()
+	}
+	return ro.trackCall(call)
+}
+
+// startPipelinedCall sends a call on the result of the call promise still in flight
+// Pipelined calls are not sent again after renewing expired credentials, the
+// result they refer to is gone by then.
+func (ro *BasicRemoteObject) startPipelinedCall(promise *pendingCall, name string, args []interface{}) *Future {
+	call := &pendingCall{method: name, future: newFuture(), args: args, ctx: context.Background(), promise: promise, renewed: true}
+	return ro.trackCall(call)
+}
+
+// trackCall sends call and returns its future, which may be abandoned or pipelined on
+func (ro *BasicRemoteObject) trackCall(call *pendingCall) *Future {
+	call.future.ro = ro
+	call.future.call = call
+	call.future.cancel = func(err error) {
+		// Only one of the answer and the cancellation resolves the future
+		if ro.abandonCall(call) {
+			call.future.resolve(nil, err)
+		}
+	}
+	ro.sendCall(call)
+	return call.future
+}
 
+// canPipeline reports whether the server accepts calls on results still in flight
+func (ro *BasicRemoteObject) canPipeline() bool {
+	c, ok := ro.session.(interface {
+		HasCapability(string) bool
+	})
+	return ok && c.HasCapability(PipeliningCapability)
+}
+
+// sendCall registers call under a new call id and sends it
+func (ro *BasicRemoteObject) sendCall(call *pendingCall) {
+	ro.callsMutex.Lock()
+	if ro.stale != nil {
+		err := ro.stale
+		ro.callsMutex.Unlock()
+		call.future.resolve(nil, err)
+		return
+	}
+	id := ro.id
+	callID := ro.callID
 	ro.callID++
+	call.id = callID
+	call.roID = id
+	ro.calls[callID] = call
+	data := []interface{}{id, uint64(romCall), callID, call.method, call.args}
+	if call.promise != nil {
+		data = append(data, call.promise.id)
+	}
+	ro.callsMutex.Unlock()
 
-	result := <-c
-	if result.err != nil {
-		return nil, result.err
+	err := sendContext(call.ctx, ro.session, data...)
+	if err != nil && ro.forgetCall(call) {
+		call.future.resolve(nil, err)
 	}
-	return result.value, nil
 }
 
 // SendNotification sends a notification to the remote object
 func (ro *BasicRemoteObject) SendNotification(name string, args ...interface{}) error {
-	return ro.session.sendMessage(ro.id, uint64(romNotify), name, args)
+	i := ro.interceptor()
+	if i == nil {
+		return ro.sendNotification(name, args)
+	}
+	ctx := context.WithValue(context.Background(), notificationKey{}, true)
+	_, err := i(ctx, ro, name, args, func(ctx context.Context, _ RemoteObject, name string, args []interface{}) (interface{}, error) {
+		return nil, ro.sendNotification(name, args)
+	})
+	return err
+}
+
+func (ro *BasicRemoteObject) sendNotification(name string, args []interface{}) error {
+	ro.callsMutex.Lock()
+	id, err := ro.id, ro.stale
+	ro.callsMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return ro.session.sendMessage(id, uint64(romNotify), name, args)
+}
+
+// pendingCalls returns the futures of all calls waiting for an answer
+func (ro *BasicRemoteObject) pendingCalls() []*Future {
+	ro.callsMutex.Lock()
+	defer ro.callsMutex.Unlock()
+	futures := make([]*Future, 0, len(ro.calls))
+	for _, call := range ro.calls {
+		if !call.abandoned {
+			futures = append(futures, call.future)
+		}
+	}
+	return futures
+}
+
+// removeCall removes a pending call and returns it, if it existed
+func (ro *BasicRemoteObject) removeCall(callID uint64) *pendingCall {
+	ro.callsMutex.Lock()
+	defer ro.callsMutex.Unlock()
+	call := ro.calls[callID]
+	if call != nil {
+		delete(ro.calls, callID)
+		ro.retireIfIdle(call.roID)
+	}
+	return call
+}
+
+// forgetCall removes call if it is still pending and reports whether it was
+func (ro *BasicRemoteObject) forgetCall(call *pendingCall) bool {
+	ro.callsMutex.Lock()
+	defer ro.callsMutex.Unlock()
+	if ro.calls[call.id] != call {
+		return false
+	}
+	delete(ro.calls, call.id)
+	ro.retireIfIdle(call.roID)
+	return true
+}
+
+// retire calls release once no call sent with the former id is waiting for its answer anymore
+// Answers to those calls still arrive on the former id, which must stay known until then.
+func (ro *BasicRemoteObject) retire(id uint64, release func()) {
+	ro.callsMutex.Lock()
+	defer ro.callsMutex.Unlock()
+	if ro.retiring == nil {
+		ro.retiring = make(map[uint64]func())
+	}
+	ro.retiring[id] = release
+	ro.retireIfIdle(id)
+}
+
+// retireIfIdle releases the former id if no call sent with it is pending, callsMutex must be held
+func (ro *BasicRemoteObject) retireIfIdle(id uint64) {
+	release := ro.retiring[id]
+	if release == nil {
+		return
+	}
+	for _, call := range ro.calls {
+		if call.roID == id {
+			return
+		}
+	}
+	delete(ro.retiring, id)
+	// Releasing sends a message, which must not hold up receiving answers
+	go release()
+}
+
+// abandonCall marks call as abandoned if it is still pending and reports whether it was
+// The answer is still awaited, see releaseLateResult.
+func (ro *BasicRemoteObject) abandonCall(call *pendingCall) bool {
+	ro.callsMutex.Lock()
+	defer ro.callsMutex.Unlock()
+	if ro.calls[call.id] != call || call.abandoned {
+		return false
+	}
+	call.abandoned = true
+	return true
+}
+
+// releaseLateResult deletes a remote object returned to an abandoned call on the server
+// Remote objects nested in other results are not released.
+func (ro *BasicRemoteObject) releaseLateResult(msg []interface{}) {
+	if len(msg) < 2 || msg[0] != uint64(0) {
+		return
+	}
+	if result, ok := msg[1].(RemoteObject); ok {
+		// Sending may wait for room in the send queue, which must not hold up receiving
+		go release(ro.session, result)
+	}
+}
+
+// setOwner sets the session renewing the credentials of the remote object
+func (ro *BasicRemoteObject) setOwner(s *Session) {
+	ro.callsMutex.Lock()
+	ro.owner = s
+	ro.callsMutex.Unlock()
+}
+
+func (ro *BasicRemoteObject) getOwner() *Session {
+	ro.callsMutex.Lock()
+	defer ro.callsMutex.Unlock()
+	return ro.owner
+}
+
+// invalidate fails all pending calls and every further call with err
+func (ro *BasicRemoteObject) invalidate(err error) {
+	ro.callsMutex.Lock()
+	ro.stale = err
+	calls := ro.calls
+	ro.calls = make(map[uint64]*pendingCall)
+	for id := range ro.retiring {
+		ro.retireIfIdle(id)
+	}
+	ro.callsMutex.Unlock()
+
+	for _, call := range calls {
+		if !call.abandoned {
+			call.future.resolve(nil, err)
+		}
+	}
+}
+
+// rebind makes an invalidated remote object usable again under a new id
+func (ro *BasicRemoteObject) rebind(id uint64) {
+	ro.callsMutex.Lock()
+	ro.id = id
+	ro.stale = nil
+	ro.callsMutex.Unlock()
+}
+
+// setName sets the name the remote object was registered with
+func (ro *BasicRemoteObject) setName(name string) {
+	ro.name = name
+}
+
+// remoteObjectReference returns the name and id used to send the remote object over the wire
+func (ro *BasicRemoteObject) remoteObjectReference() (string, uint64) {
+	return ro.name, ro.ID()
 }
 
 func shiftSlice(s []interface{}) (interface{}, []interface{}) {
@@ -87,6 +624,32 @@ func (ro *BasicRemoteObject) receive(msg []interface{}) error {
 	}
 
 	switch opcode {
+	case romCall:
+		iCallID, msg := shiftSlice(msg)
+		if msg == nil {
+			return errors.New("unexpected end of message")
+		}
+		callID, ok := iCallID.(uint64)
+		if !ok {
+			return errors.New("call id must be an uint")
+		}
+		name, msg := shiftSlice(msg)
+		if msg == nil {
+			return errors.New("unexpected end of message")
+		}
+		nameString, ok := name.(string)
+		if !ok {
+			return errors.New("method name must be a string")
+		}
+		args, _ := shiftSlice(msg)
+		argsSlice, ok := args.([]interface{})
+		if !ok {
+			return errors.New("call args must be a list")
+		}
+
+		go ro.answerCall(callID, nameString, argsSlice)
+		return nil
+
 	case romAnswer:
 		iCallID, msg := shiftSlice(msg)
 		if msg == nil {
@@ -97,43 +660,52 @@ func (ro *BasicRemoteObject) receive(msg []interface{}) error {
 			return errors.New("call id must be an uint")
 		}
 
-		c := ro.callResults[callID]
-		defer close(c)
+		call := ro.removeCall(callID)
+		if call == nil {
+			return errors.New("received answer to nonexistant call")
+		}
+		if call.abandoned {
+			ro.releaseLateResult(msg)
+			return nil
+		}
+		// The caller waits for the future, so it fails together with the connection
+		fail := func(err error) error {
+			call.future.resolve(nil, err)
+			return err
+		}
 
 		iStatus, msg := shiftSlice(msg)
 		if msg == nil {
-			return errors.New("unexpected end of message")
+			return fail(errors.New("unexpected end of message"))
 		}
 		status, ok := iStatus.(uint64)
 		if !ok {
-			return errors.New("status must be an uint")
+			return fail(errors.New("status must be an uint"))
 		}
 
 		switch status {
 		case 0:
 			result, msg := shiftSlice(msg)
 			if msg == nil {
-				return errors.New("unexpected end of message")
+				return fail(errors.New("unexpected end of message"))
 			}
-
-			if c == nil {
-				return errors.New("received answer to nonexistant call")
-			}
-			c <- romResult{value: result}
+			call.future.resolve(result, nil)
 
 		case 1:
-			err, msg := shiftSlice(msg)
+			payload, msg := shiftSlice(msg)
 			if msg == nil {
-				return errors.New("unexpected end of message")
+				return fail(errors.New("unexpected end of message"))
 			}
-			errString, ok := err.(string)
-			if !ok {
-				return errors.New("error must be a string")
+			remoteErr := newRemoteError(ro, callID, call.method, payload)
+			if r, ok := ro.session.(interface {
+				renewExpired(*BasicRemoteObject, *pendingCall, *RemoteError) bool
+			}); ok && r.renewExpired(ro, call, remoteErr) {
+				return nil
 			}
-			c <- romResult{err: errors.New(errString)}
+			call.future.resolve(nil, remoteErr)
 
 		default:
-			return errors.New("unknown status in remote object")
+			return fail(errors.New("unknown status in remote object"))
 		}
 
 		return nil
@@ -156,14 +728,43 @@ func (ro *BasicRemoteObject) receive(msg []interface{}) error {
 			return errors.New("notification args must be a string")
 		}
 
-		f := ro.Notifications[nameString]
-		if f == nil {
-			return errors.New("unknown notification " + nameString)
-		}
-
-		go f(argsSlice)
+		ro.notify(nameString, argsSlice)
 		return nil
 	default:
 		return errors.New("unknown opcode in remote object")
 	}
 }
+
+// answerCall runs a call from the server on the handler and sends back the answer
+func (ro *BasicRemoteObject) answerCall(callID uint64, name string, args []interface{}) {
+	result, err := ro.handleCall(name, args)
+	if err == nil {
+		// Results that cannot be sent are reported to the server as errors
+		err = (&message{buffer: &bytes.Buffer{}}).send(result)
+		if err != nil {
+			err = fmt.Errorf("cannot send result of %s: %s", name, err.Error())
+		}
+	}
+
+	if err != nil {
+		err = ro.session.sendMessage(ro.ID(), uint64(romAnswer), callID, uint64(1), err.Error())
+	} else {
+		err = ro.session.sendMessage(ro.ID(), uint64(romAnswer), callID, uint64(0), result)
+	}
+	if err != nil {
+		log.Printf("univedo: could not answer call %s on remote object %d: %s", name, ro.ID(), err.Error())
+	}
+}
+
+// handleCall runs a call from the server on the handler, turning panics into errors
+func (ro *BasicRemoteObject) handleCall(name string, args []interface{}) (result interface{}, err error) {
+	if ro.handler == nil {
+		return nil, errors.New("remote object does not accept calls")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("%s panicked: %v", name, r)
+		}
+	}()
+	return ro.handler.HandleCall(name, args)
+}
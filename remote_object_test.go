@@ -1,8 +1,11 @@
 package univedo
 
 import (
+	"context"
+	"errors"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
+	"time"
 )
 
 type testSession struct {
@@ -54,5 +57,145 @@ func TestRemoteObject(t *testing.T) {
 			So(err, ShouldNotBeNil)
 			So(res, ShouldBeNil)
 		})
+
+		Convey("returns remote errors with context", func() {
+			s := new(testSession)
+			ro := NewBasicRO(23, s)
+			ro.setName("com.example.foo")
+			s.onMessage = func() {
+				ro.receive([]interface{}{uint64(2), uint64(0), uint64(1), "boom"})
+			}
+			_, err := ro.CallROM("foo")
+			var remoteErr *RemoteError
+			So(errors.As(err, &remoteErr), ShouldBeTrue)
+			So(remoteErr.ObjectID, ShouldEqual, 23)
+			So(remoteErr.ObjectName, ShouldEqual, "com.example.foo")
+			So(remoteErr.Method, ShouldEqual, "foo")
+			So(remoteErr.CallID, ShouldEqual, 0)
+			So(remoteErr.Message, ShouldEqual, "boom")
+			So(err.Error(), ShouldEqual, "univedo: foo on com.example.foo (23) failed: boom")
+		})
+
+		Convey("fails calls with malformed answers", func() {
+			for _, answer := range [][]interface{}{
+				{uint64(2), uint64(0), "ok", 42},
+				{uint64(2), uint64(0), uint64(0)},
+				{uint64(2), uint64(0), uint64(1)},
+				{uint64(2), uint64(0), uint64(7), 42},
+			} {
+				s := new(testSession)
+				ro := NewBasicRO(23, s)
+				var rcvErr error
+				received := make(chan struct{})
+				s.onMessage = func() {
+					rcvErr = ro.receive(answer)
+					close(received)
+				}
+				_, err := ro.CallROM("foo")
+				<-received
+				So(rcvErr, ShouldNotBeNil)
+				So(err, ShouldEqual, rcvErr)
+			}
+		})
+
+		Convey("forgets calls when the context is done", func() {
+			s := new(testSession)
+			s.onMessage = func() {}
+			ro := NewBasicRO(23, s)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			defer cancel()
+			_, err := ro.CallROMContext(ctx, "foo")
+			So(err, ShouldEqual, context.DeadlineExceeded)
+			So(ro.pendingCalls(), ShouldBeEmpty)
+
+			Convey("and releases remote objects answered too late", func() {
+				sent := make(chan struct{}, 1)
+				s.onMessage = func() { sent <- struct{}{} }
+				late := NewBasicRO(42, s)
+				So(ro.receive([]interface{}{uint64(2), uint64(0), uint64(0), late}), ShouldBeNil)
+				<-sent
+				So(s.msg, ShouldResemble, []interface{}{uint64(42), uint64(romDelete)})
+			})
+		})
+
+		Convey("keeps structured remote errors", func() {
+			s := new(testSession)
+			ro := NewBasicRO(23, s)
+			payload := map[string]interface{}{"code": uint64(401), "message": "denied"}
+			s.onMessage = func() {
+				ro.receive([]interface{}{uint64(2), uint64(0), uint64(1), payload})
+			}
+			_, err := ro.CallROM("foo")
+			var remoteErr *RemoteError
+			So(errors.As(err, &remoteErr), ShouldBeTrue)
+			So(remoteErr.Code, ShouldEqual, 401)
+			So(remoteErr.Message, ShouldEqual, "denied")
+			So(remoteErr.Details, ShouldResemble, payload)
+		})
+
+		Convey("dispatches notifications to all subscribers", func() {
+			ro := NewBasicRO(23, new(testSession))
+			c1 := make(chan []interface{}, 1)
+			c2 := make(chan []interface{}, 1)
+			ro.Subscribe("foo", func(args []interface{}) { c1 <- args })
+			ro.Subscribe("foo", func(args []interface{}) { c2 <- args })
+			err := ro.receive([]interface{}{uint64(3), "foo", []interface{}{"bar"}})
+			So(err, ShouldBeNil)
+			So(<-c1, ShouldResemble, []interface{}{"bar"})
+			So(<-c2, ShouldResemble, []interface{}{"bar"})
+		})
+
+		Convey("delivers notifications to channels", func() {
+			ro := NewBasicRO(23, new(testSession))
+			c, unsubscribe := ro.SubscribeChan("foo")
+			err := ro.receive([]interface{}{uint64(3), "foo", []interface{}{uint64(42)}})
+			So(err, ShouldBeNil)
+			So(<-c, ShouldResemble, []interface{}{uint64(42)})
+
+			Convey("without blocking other notifications when full", func() {
+				bar := make(chan []interface{}, 1)
+				ro.Subscribe("bar", func(args []interface{}) { bar <- args })
+				for i := 0; i < subscribeChanSize+1; i++ {
+					ro.receive([]interface{}{uint64(3), "foo", []interface{}{}})
+				}
+				ro.receive([]interface{}{uint64(3), "bar", []interface{}{}})
+				So(<-bar, ShouldResemble, []interface{}{})
+				So(len(c), ShouldEqual, subscribeChanSize)
+			})
+
+			Convey("and closes them on unsubscribe", func() {
+				unsubscribe()
+				unsubscribe()
+				_, ok := <-c
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("falls back to the deprecated notification map", func() {
+			ro := NewBasicRO(23, new(testSession))
+			c := make(chan []interface{}, 1)
+			ro.Notifications["foo"] = func(args []interface{}) { c <- args }
+			err := ro.receive([]interface{}{uint64(3), "foo", []interface{}{"bar"}})
+			So(err, ShouldBeNil)
+			So(<-c, ShouldResemble, []interface{}{"bar"})
+		})
+
+		Convey("unsubscribes", func() {
+			ro := NewBasicRO(23, new(testSession))
+			unhandled := make(chan string, 1)
+			ro.SetUnhandledNotificationHandler(func(name string, args []interface{}) { unhandled <- name })
+			unsubscribe := ro.Subscribe("foo", func(args []interface{}) {})
+			unsubscribe()
+			unsubscribe()
+			err := ro.receive([]interface{}{uint64(3), "foo", []interface{}{}})
+			So(err, ShouldBeNil)
+			So(<-unhandled, ShouldEqual, "foo")
+		})
+
+		Convey("ignores unknown notifications", func() {
+			ro := NewBasicRO(23, new(testSession))
+			err := ro.receive([]interface{}{uint64(3), "foo", []interface{}{}})
+			So(err, ShouldBeNil)
+		})
 	})
 }
@@ -1,8 +1,30 @@
 package univedo
 
+import (
+	"sync"
+)
+
 // Session on univedo
 type Session struct {
 	*BasicRemoteObject
+
+	// bucket and provider are kept to reopen the session after a reconnect
+	bucket   string
+	provider CredentialsProvider
+
+	// authMutex guards authGeneration, which counts renewals of expired credentials
+	authMutex      sync.Mutex
+	authGeneration uint64
+
+	// perspectivesMutex guards perspectives
+	perspectivesMutex sync.Mutex
+	perspectives      []openPerspective
+}
+
+// A perspective opened through a session, restored together with it
+type openPerspective struct {
+	name string
+	ro   RemoteObject
 }
 
 // Ping the server
@@ -16,8 +38,82 @@ func (s *Session) ApplyUTS(uts string) error {
 	return err
 }
 
-func newSession(id uint64, send sender) RemoteObject {
-	return &Session{NewBasicRO(id, send)}
+// GetPerspective opens the perspective with the given name
+// Perspectives opened this way are reopened when the connection reconnects.
+func (s *Session) GetPerspective(name string) (RemoteObject, error) {
+	perspective, err := getRoFromROM(s, "getPerspective", name)
+	if err != nil {
+		return nil, err
+	}
+	if o, ok := perspective.(interface {
+		setOwner(*Session)
+	}); ok {
+		o.setOwner(s)
+	}
+	s.perspectivesMutex.Lock()
+	s.perspectives = append(s.perspectives, openPerspective{name: name, ro: perspective})
+	s.perspectivesMutex.Unlock()
+	return perspective, nil
+}
+
+// credentialsGeneration returns the number of times the expired credentials of s were renewed
+func (s *Session) credentialsGeneration() uint64 {
+	s.authMutex.Lock()
+	defer s.authMutex.Unlock()
+	return s.authGeneration
+}
+
+// ClosePerspective releases a perspective opened with GetPerspective on the server
+// The perspective is no longer reopened after a reconnect.
+func (s *Session) ClosePerspective(perspective RemoteObject) error {
+	s.forgetPerspective(perspective)
+	return release(s.session, perspective)
+}
+
+// Close releases the session and its perspectives on the server
+// They are no longer reopened after a reconnect.
+func (s *Session) Close() error {
+	s.perspectivesMutex.Lock()
+	perspectives := s.perspectives
+	s.perspectives = nil
+	s.perspectivesMutex.Unlock()
+
+	var err error
+	for _, p := range perspectives {
+		if pErr := release(s.session, p.ro); err == nil {
+			err = pErr
+		}
+	}
+	if sErr := release(s.session, s); err == nil {
+		err = sErr
+	}
+	return err
+}
+
+// forgetPerspective stops restoring ro after reconnects
+func (s *Session) forgetPerspective(ro RemoteObject) {
+	s.perspectivesMutex.Lock()
+	defer s.perspectivesMutex.Unlock()
+	for i, p := range s.perspectives {
+		if p.ro == ro {
+			s.perspectives = append(s.perspectives[:i:i], s.perspectives[i+1:]...)
+			return
+		}
+	}
+}
+
+// release deletes ro on the server, connections also forget it
+func release(send Sender, ro RemoteObject) error {
+	if r, ok := send.(interface {
+		releaseRemoteObject(RemoteObject) error
+	}); ok {
+		return r.releaseRemoteObject(ro)
+	}
+	return send.sendMessage(ro.ID(), uint64(romDelete))
+}
+
+func newSession(id uint64, send Sender) RemoteObject {
+	return &Session{BasicRemoteObject: NewBasicRO(id, send)}
 }
 
 func init() {
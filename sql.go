@@ -1,86 +1,172 @@
 package univedo
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"errors"
 	"io"
-	"net/url"
+	"log"
+	"reflect"
 	"strconv"
-	"strings"
+	"sync"
 )
 
 // UnivedoDriver implements the interface required by database/sql
-type UnivedoDriver struct{}
+// To configure the connections, e.g. with interceptors, pass a connector from
+// NewConnector to sql.OpenDB, or register another UnivedoDriver with DialOptions
+// under a different name.
+type UnivedoDriver struct {
+	DialOptions []DialOption
+
+	// Credentials are used instead of the query parameters of the data source name
+	Credentials CredentialsProvider
+}
 
 // Open a new connection
-// You should probably use database/sql instead of this directly.
-func (UnivedoDriver) Open(name string) (driver.Conn, error) {
-	// Extract the perspective uuid from url
-	u, err := url.Parse(name)
+// You should probably use database/sql instead of this directly. See Config for
+// the format of name.
+func (d UnivedoDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.OpenConnector(name)
 	if err != nil {
 		return nil, err
 	}
-	pathComponents := strings.Split(strings.Trim(u.Path, "/"), "/")
-	if len(pathComponents) != 2 {
-		return nil, errors.New("requires bucket and perspective name")
-	}
-	bucketName := pathComponents[0]
-	perspectiveName := pathComponents[1]
+	return c.Connect(context.Background())
+}
 
-	u.Path = "/"
+// Conn implements a connection as required by database/sql
+type Conn struct {
+	Connection  *Connection
+	session     *Session
+	perspective RemoteObject
 
-	// Extract credentials from url
-	creds := map[string]interface{}{}
-	for k, v := range u.Query() {
-		creds[k] = v[0]
-	}
-	u.RawQuery = ""
+	// shared connections are left open by Close
+	shared bool
 
-	// Open connection and perspective
-	connection, err := Dial(u.String())
-	if err != nil {
-		return nil, err
-	}
+	// mutex guards tx, database/sql may end transactions from another goroutine when their context is done
+	mutex sync.Mutex
+	// tx is the transaction in progress, statements are prepared in it
+	tx *tx
+}
 
-	session, err := connection.GetSession(bucketName, creds)
-	if err != nil {
-		return nil, err
+// isolationLevels maps the isolation levels of database/sql to their names in univedo
+var isolationLevels = map[sql.IsolationLevel]string{
+	sql.LevelReadUncommitted: "read_uncommitted",
+	sql.LevelReadCommitted:   "read_committed",
+	sql.LevelWriteCommitted:  "write_committed",
+	sql.LevelRepeatableRead:  "repeatable_read",
+	sql.LevelSnapshot:        "snapshot",
+	sql.LevelSerializable:    "serializable",
+	sql.LevelLinearizable:    "linearizable",
+}
+
+// Begin a transaction as required by database/sql
+func (conn *Conn) Begin() (driver.Tx, error) {
+	return conn.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx begins a transaction with options as required by database/sql
+// Isolation levels other than the default are passed on to the server, which
+// rejects the ones it does not support.
+func (conn *Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if conn.currentTx() != nil {
+		return nil, errors.New("transaction already in progress")
+	}
+	options := map[string]interface{}{"readOnly": opts.ReadOnly}
+	if level := sql.IsolationLevel(opts.Isolation); level != sql.LevelDefault {
+		name, ok := isolationLevels[level]
+		if !ok {
+			return nil, errors.New("unknown isolation level " + level.String())
+		}
+		options["isolation"] = name
 	}
 
-	perspective, err := getRoFromROM(session, "getPerspective", perspectiveName)
+	txI, err := conn.perspective.CallROMContext(ctx, "beginTransaction", options)
 	if err != nil {
 		return nil, err
 	}
+	txRO, ok := txI.(RemoteObject)
+	if !ok {
+		return nil, errors.New("expected com.univedo.transaction from beginTransaction")
+	}
+	t := &tx{conn: conn, ro: txRO}
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if conn.tx != nil {
+		t.ro.CallROMAsync("rollback")
+		return nil, errors.New("transaction already in progress")
+	}
+	conn.tx = t
+	return t, nil
+}
 
-	return &Conn{Connection: connection, perspective: perspective}, nil
+// currentTx returns the transaction in progress, if any
+func (conn *Conn) currentTx() *tx {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	return conn.tx
 }
 
-// Conn implements a connection as required by database/sql
-type Conn struct {
-	Connection  *Connection
-	perspective RemoteObject
+// A transaction in univedo
+// Implements the Tx interface from sql/driver
+type tx struct {
+	conn *Conn
+	ro   RemoteObject
 }
 
-// Begin a transaction as required by database/sql
-func (conn *Conn) Begin() (driver.Tx, error) {
-	return nil, errors.New("NI Begin()")
+func (t *tx) Commit() error {
+	t.finish()
+	_, err := t.ro.CallROM("commit")
+	return err
+}
+
+func (t *tx) Rollback() error {
+	t.finish()
+	_, err := t.ro.CallROM("rollback")
+	return err
+}
+
+// finish makes statements of the connection no longer run in the transaction
+func (t *tx) finish() {
+	t.conn.mutex.Lock()
+	defer t.conn.mutex.Unlock()
+	if t.conn.tx == t {
+		t.conn.tx = nil
+	}
 }
 
 // Close the connection as required by database/sql
 func (conn *Conn) Close() error {
-	// TODO error handling
-	conn.Connection.Close()
-	return nil
+	if conn.shared {
+		// The connection stays open for others, only the session of conn is released
+		return conn.session.Close()
+	}
+	return conn.Connection.Close()
 }
 
 // Prepare a statement as required by database/sql
 func (conn *Conn) Prepare(query string) (driver.Stmt, error) {
-	queryRO, err := getRoFromROM(conn.perspective, "query")
-	if err != nil {
-		return nil, err
+	return conn.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext prepares a statement as required by database/sql
+func (conn *Conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	target := conn.perspective
+	if t := conn.currentTx(); t != nil {
+		target = t.ro
+	}
+	var stmtRO interface{}
+	var err error
+	if conn.Connection.HasCapability(PipeliningCapability) {
+		// query and prepare are sent back to back, costing a single round trip
+		stmtRO, err = await(ctx, target.CallROMAsync("query").CallROMAsync("prepare", query))
+	} else {
+		var queryRO RemoteObject
+		queryRO, err = callForRO(ctx, target, "query")
+		if err == nil {
+			stmtRO, err = callForRO(ctx, queryRO, "prepare", query)
+		}
 	}
-	stmtRO, err := getRoFromROM(queryRO, "prepare", query)
 	if err != nil {
 		return nil, err
 	}
@@ -92,20 +178,51 @@ func (conn *Conn) Prepare(query string) (driver.Stmt, error) {
 	return s, nil
 }
 
+// QueryContext prepares and runs a query as required by database/sql
+func (conn *Conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	s, err := conn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return s.(*stmt).QueryContext(ctx, args)
+}
+
+// ExecContext prepares and runs a statement as required by database/sql
+func (conn *Conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	s, err := conn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return s.(*stmt).ExecContext(ctx, args)
+}
+
+// Ping checks that the session is alive as required by database/sql
+func (conn *Conn) Ping(ctx context.Context) error {
+	_, err := conn.session.CallROMContext(ctx, "ping", "ping")
+	return err
+}
+
 // A statement in univedo
 // Implements the Stmt interface from sql/driver
 type stmt struct {
 	*BasicRemoteObject
-	columnNames chan []string
+
+	// columns is set before columnsSet is closed
+	columns    []string
+	columnsSet chan struct{}
+
+	// columnTypes is set by the setColumnTypes notification, nil if unknown
+	// Read it only after waiting for delivered, notifications run on their own goroutine.
+	columnTypes []columnType
 }
 
-func newStatement(id uint64, send sender) RemoteObject {
+func newStatement(id uint64, send Sender) RemoteObject {
 	s := new(stmt)
 	s.BasicRemoteObject = NewBasicRO(id, send)
 
-	s.columnNames = make(chan []string)
+	s.columnsSet = make(chan struct{})
 
-	s.Notifications["setColumnNames"] = func(args []interface{}) {
+	s.Subscribe("setColumnNames", func(args []interface{}) {
 		// TODO error handling
 		if len(args) != 1 {
 			panic("setColumnNames without args")
@@ -122,11 +239,19 @@ func newStatement(id uint64, send sender) RemoteObject {
 			}
 			colNames[i] = str
 		}
-		s.columnNames <- colNames
-		close(s.columnNames)
-	}
-
-	s.Notifications["setColumnTypes"] = func(args []interface{}) {}
+		s.columns = colNames
+		close(s.columnsSet)
+	})
+
+	s.Subscribe("setColumnTypes", func(args []interface{}) {
+		types, err := parseColumnTypes(args)
+		if err != nil {
+			// Column types are optional, results of the statement just do not know them
+			log.Printf("univedo: ignoring column types of statement %d: %s", s.ID(), err.Error())
+			return
+		}
+		s.columnTypes = types
+	})
 
 	return s
 }
@@ -136,17 +261,41 @@ func (s *stmt) Close() error {
 }
 
 func (s *stmt) Exec(binds []driver.Value) (driver.Result, error) {
-	return execStatement(s, binds)
+	return s.ExecContext(context.Background(), namedValues(binds))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return execStatement(ctx, s, args)
 }
 
 func (s *stmt) Query(binds []driver.Value) (driver.Rows, error) {
-	cols := <-s.columnNames
+	return s.QueryContext(context.Background(), namedValues(binds))
+}
 
-	result, err := execStatement(s, binds)
+// QueryContext runs the query, cancelling ctx aborts reading the result
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	// The server announces the names of the columns right after preparing
+	select {
+	case <-s.columnsSet:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	result, err := execStatement(ctx, s, args)
 	if err != nil {
 		return nil, err
 	}
-	result.cols = cols
+
+	// Column types, if sent at all, arrive before the answer to execute
+	select {
+	case <-s.delivered():
+	case <-ctx.Done():
+		result.cancel()
+		return nil, ctx.Err()
+	}
+	result.cols = s.columns
+	result.colTypes = s.columnTypes
+	result.ctx = ctx
 	return result, nil
 }
 
@@ -159,22 +308,34 @@ func (s *stmt) NumInput() int {
 type result struct {
 	*BasicRemoteObject
 	cols           []string
+	colTypes       []columnType
+	ctx            context.Context
 	rows           chan []interface{}
+	aborted        chan struct{}
+	abortOnce      sync.Once
+	cancelOnce     sync.Once
 	errors         chan error
 	lastInsertedID chan uint64
 	rowsAffected   chan uint64
 }
 
-func newResult(id uint64, s sender) RemoteObject {
+func newResult(id uint64, s Sender) RemoteObject {
+	return newResultWithFetchSize(id, s, defaultFetchSize)
+}
+
+// newResultWithFetchSize returns a result buffering up to fetchSize rows ahead of the reader
+func newResultWithFetchSize(id uint64, s Sender, fetchSize int) RemoteObject {
 	r := new(result)
 	r.BasicRemoteObject = NewBasicRO(id, s)
 
-	r.rows = make(chan []interface{}, 100)
+	r.rows = make(chan []interface{}, fetchSize)
+	r.aborted = make(chan struct{})
+	r.ctx = context.Background()
 	r.lastInsertedID = make(chan uint64, 1)
 	r.rowsAffected = make(chan uint64, 1)
 	r.errors = make(chan error, 1)
 
-	r.Notifications["setError"] = func(args []interface{}) {
+	r.Subscribe("setError", func(args []interface{}) {
 		if len(args) != 1 {
 			panic("setError without args")
 		}
@@ -183,13 +344,13 @@ func newResult(id uint64, s sender) RemoteObject {
 			panic("setError without error string")
 		}
 		r.errors <- errors.New(err)
-	}
+	})
 
-	r.Notifications["setComplete"] = func([]interface{}) {
+	r.Subscribe("setComplete", func([]interface{}) {
 		close(r.rows)
-	}
+	})
 
-	r.Notifications["setTuple"] = func(args []interface{}) {
+	r.Subscribe("setTuple", func(args []interface{}) {
 		// TODO error handling
 		if len(args) != 1 {
 			panic("setTuple without args")
@@ -198,10 +359,13 @@ func newResult(id uint64, s sender) RemoteObject {
 		if !ok {
 			panic("setTuple without list")
 		}
-		r.rows <- row
-	}
+		select {
+		case r.rows <- row:
+		case <-r.aborted:
+		}
+	})
 
-	r.Notifications["setId"] = func(args []interface{}) {
+	r.Subscribe("setId", func(args []interface{}) {
 		// TODO error handling
 		if len(args) != 1 {
 			panic("setId without args")
@@ -213,9 +377,9 @@ func newResult(id uint64, s sender) RemoteObject {
 		r.lastInsertedID <- id
 		r.rowsAffected <- 1
 		close(r.lastInsertedID)
-	}
+	})
 
-	r.Notifications["setNAffectedRecords"] = func(args []interface{}) {
+	r.Subscribe("setNAffectedRecords", func(args []interface{}) {
 		// TODO error handling
 		if len(args) != 1 {
 			panic("setNAffectedRecords without args")
@@ -226,22 +390,80 @@ func newResult(id uint64, s sender) RemoteObject {
 		}
 		r.rowsAffected <- num
 		close(r.rowsAffected)
-	}
+	})
 
 	return r
 }
 
+// Close stops reading the result, rows still sent by the server are dropped
 func (r *result) Close() error {
-	// TODO implement
+	r.abortOnce.Do(func() {
+		close(r.aborted)
+	})
 	return nil
 }
 
+// cancel aborts the result and releases it on the server
+func (r *result) cancel() {
+	r.Close()
+	r.cancelOnce.Do(func() {
+		r.session.sendMessage(r.ID(), uint64(romDelete))
+	})
+}
+
 func (r *result) Columns() []string {
 	return r.cols
 }
 
+// ColumnTypeDatabaseTypeName returns the univedo type of a column, e.g. INTEGER or TEXT, empty if unknown
+func (r *result) ColumnTypeDatabaseTypeName(index int) string {
+	if index < 0 || index >= len(r.colTypes) {
+		return ""
+	}
+	return r.colTypes[index].databaseTypeName()
+}
+
+// ColumnTypeScanType returns the Go type of the values of a column, interface{} if unknown
+func (r *result) ColumnTypeScanType(index int) reflect.Type {
+	if index < 0 || index >= len(r.colTypes) {
+		return scanTypeAny
+	}
+	return r.colTypes[index].scanType()
+}
+
+// ColumnTypeLength returns the maximum length of char, text and blob columns
+func (r *result) ColumnTypeLength(index int) (int64, bool) {
+	if index < 0 || index >= len(r.colTypes) {
+		return 0, false
+	}
+	return r.colTypes[index].variableLength()
+}
+
+// ColumnTypeNullable reports whether a column may be NULL, if known
+func (r *result) ColumnTypeNullable(index int) (bool, bool) {
+	if index < 0 || index >= len(r.colTypes) {
+		return false, false
+	}
+	return r.colTypes[index].nullable()
+}
+
+// ColumnTypePrecisionScale is not supported, univedo has no decimal columns
+func (r *result) ColumnTypePrecisionScale(index int) (int64, int64, bool) {
+	return 0, 0, false
+}
+
 func (r *result) Next(dest []driver.Value) error {
+	// Rows already received must not hide the cancellation
+	if err := r.ctx.Err(); err != nil {
+		r.cancel()
+		return err
+	}
 	select {
+	case <-r.ctx.Done():
+		r.cancel()
+		return r.ctx.Err()
+	case <-r.aborted:
+		return io.EOF
 	case err := <-r.errors:
 		return err
 	case row, ok := <-r.rows:
@@ -283,12 +505,16 @@ func (r *result) RowsAffected() (int64, error) {
 	}
 }
 
-func execStatement(stmt *stmt, binds []driver.Value) (*result, error) {
+func execStatement(ctx context.Context, stmt *stmt, args []driver.NamedValue) (*result, error) {
 	bindsI := make(map[string]interface{})
-	for i, v := range binds {
-		bindsI[strconv.Itoa(i)] = v
+	for _, arg := range args {
+		name := arg.Name
+		if name == "" {
+			name = strconv.Itoa(arg.Ordinal - 1)
+		}
+		bindsI[name] = arg.Value
 	}
-	r, err := getRoFromROM(stmt, "execute", bindsI)
+	r, err := callForRO(ctx, stmt, "execute", bindsI)
 	if err != nil {
 		return nil, err
 	}
@@ -299,8 +525,34 @@ func execStatement(stmt *stmt, binds []driver.Value) (*result, error) {
 	return result, nil
 }
 
+// namedValues converts the arguments of the legacy driver interfaces
+func namedValues(binds []driver.Value) []driver.NamedValue {
+	args := make([]driver.NamedValue, len(binds))
+	for i, v := range binds {
+		args[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return args
+}
+
+// callForRO calls a remote method returning a remote object
+func callForRO(ctx context.Context, ro RemoteObject, rom string, args ...interface{}) (RemoteObject, error) {
+	roI, err := ro.CallROMContext(ctx, rom, args...)
+	if err != nil {
+		return nil, err
+	}
+	result, ok := roI.(RemoteObject)
+	if !ok {
+		return nil, errors.New("expected RO as return value")
+	}
+	return result, nil
+}
+
 func getRoFromROM(ro RemoteObject, rom string, args ...interface{}) (RemoteObject, error) {
-	roI, err := ro.CallROM(rom, args...)
+	return getRoFromFuture(ro.CallROMAsync(rom, args...))
+}
+
+func getRoFromFuture(f *Future) (RemoteObject, error) {
+	roI, err := f.Wait()
 	if err != nil {
 		return nil, err
 	}
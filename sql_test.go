@@ -1,14 +1,19 @@
 package univedo
 
 import (
+	"context"
 	"io/ioutil"
 	. "github.com/smartystreets/goconvey/convey"
 
 	"database/sql"
+	"database/sql/driver"
+	"math"
+	"reflect"
 	"testing"
+	"time"
 )
 
-const testPerspectiveURL = "ws://localhost:9011/db2e64b0-b294-4a0e-85b2-88903ee80943/cefb4ed2-4ce3-4825-8550-b68a3c142f0a?username=marvin"
+var testPerspectiveURL = testURL + "db2e64b0-b294-4a0e-85b2-88903ee80943/cefb4ed2-4ce3-4825-8550-b68a3c142f0a?username=marvin"
 
 func setupDB() {
 	connection, err := Dial(testURL)
@@ -22,6 +27,13 @@ func setupDB() {
 	err = session.ApplyUTS(string(testFile))
 	So(err, ShouldBeNil)
 	defer connection.Close()
+
+	// The real server also describes the fields of its system tables, the fake server
+	// only those of test.uts, so seed rows standing in for them
+	for i := 0; i < 64; i++ {
+		err = testServer.Exec("db2e64b0-b294-4a0e-85b2-88903ee80943", "insert into fields_inclusive (table_id) values (?)", i)
+		So(err, ShouldBeNil)
+	}
 }
 
 func TestSql(t *testing.T) {
@@ -97,3 +109,200 @@ func TestSql(t *testing.T) {
 		})
 	})
 }
+
+func TestTransactions(t *testing.T) {
+	Convey("transactions", t, func() {
+		setupDB()
+		db, err := sql.Open("univedo", testPerspectiveURL)
+		So(err, ShouldBeNil)
+		defer db.Close()
+
+		count := func(q interface {
+			QueryRow(string, ...interface{}) *sql.Row
+		}) int {
+			var n int
+			So(q.QueryRow("select count(*) from fields_inclusive").Scan(&n), ShouldBeNil)
+			return n
+		}
+		before := count(db)
+
+		Convey("commit", func() {
+			tx, err := db.Begin()
+			So(err, ShouldBeNil)
+			_, err = tx.Exec("insert into fields_inclusive (table_id) values (?)", 1000)
+			So(err, ShouldBeNil)
+			So(count(tx), ShouldEqual, before+1)
+			So(count(db), ShouldEqual, before)
+			So(tx.Commit(), ShouldBeNil)
+			So(count(db), ShouldEqual, before+1)
+		})
+
+		Convey("roll back", func() {
+			tx, err := db.Begin()
+			So(err, ShouldBeNil)
+			_, err = tx.Exec("insert into fields_inclusive (table_id) values (?)", 1000)
+			So(err, ShouldBeNil)
+			So(tx.Rollback(), ShouldBeNil)
+			So(count(db), ShouldEqual, before)
+		})
+
+		Convey("fail to commit conflicting writes", func() {
+			tx, err := db.Begin()
+			So(err, ShouldBeNil)
+			_, err = tx.Exec("insert into fields_inclusive (table_id) values (?)", 1000)
+			So(err, ShouldBeNil)
+			_, err = db.Exec("insert into fields_inclusive (table_id) values (?)", 1001)
+			So(err, ShouldBeNil)
+			So(tx.Commit(), ShouldNotBeNil)
+			So(count(db), ShouldEqual, before+1)
+		})
+
+		Convey("are read-only", func() {
+			tx, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+			So(err, ShouldBeNil)
+			So(count(tx), ShouldEqual, before)
+			_, err = tx.Exec("insert into fields_inclusive (table_id) values (?)", 1000)
+			So(err, ShouldNotBeNil)
+			So(tx.Rollback(), ShouldBeNil)
+		})
+
+		Convey("pass isolation levels", func() {
+			tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable})
+			So(err, ShouldBeNil)
+			So(tx.Commit(), ShouldBeNil)
+			_, err = db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("end while statements are prepared", func() {
+			c, err := UnivedoDriver{}.Open(testPerspectiveURL)
+			So(err, ShouldBeNil)
+			defer c.Close()
+			conn := c.(*Conn)
+			tx, err := conn.Begin()
+			So(err, ShouldBeNil)
+			done := make(chan error, 1)
+			go func() { done <- tx.Rollback() }()
+			// Racing the rollback, the statement is prepared in the transaction or fails
+			// in the finished one, only the transaction state is checked
+			conn.Prepare("select count(*) from fields_inclusive")
+			So(<-done, ShouldBeNil)
+			So(conn.currentTx(), ShouldBeNil)
+			_, err = conn.Prepare("select count(*) from fields_inclusive")
+			So(err, ShouldBeNil)
+
+			_, err = conn.Begin()
+			So(err, ShouldBeNil)
+			So(tx.Commit(), ShouldNotBeNil)
+			So(conn.currentTx(), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestSqlContext(t *testing.T) {
+	Convey("contexts", t, func() {
+		setupDB()
+		db, err := sql.Open("univedo", testPerspectiveURL)
+		So(err, ShouldBeNil)
+		defer db.Close()
+
+		Convey("ping the session", func() {
+			So(db.PingContext(context.Background()), ShouldBeNil)
+		})
+
+		Convey("fail queries and execs when done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := db.QueryContext(ctx, "select * from fields_inclusive")
+			So(err, ShouldEqual, context.Canceled)
+			_, err = db.ExecContext(ctx, "insert into fields_inclusive (table_id) values (?)", 1)
+			So(err, ShouldEqual, context.Canceled)
+		})
+
+		Convey("abort streaming results", func() {
+			c, err := UnivedoDriver{}.Open(testPerspectiveURL)
+			So(err, ShouldBeNil)
+			defer c.Close()
+			ctx, cancel := context.WithCancel(context.Background())
+			rows, err := c.(*Conn).QueryContext(ctx, "select * from fields_inclusive", nil)
+			So(err, ShouldBeNil)
+			dest := make([]driver.Value, len(rows.Columns()))
+			So(rows.Next(dest), ShouldBeNil)
+			cancel()
+			So(rows.Next(dest), ShouldEqual, context.Canceled)
+			So(rows.Close(), ShouldBeNil)
+		})
+
+		Convey("run prepared statements more than once", func() {
+			stmt, err := db.PrepareContext(context.Background(), "select count(*) from fields_inclusive")
+			So(err, ShouldBeNil)
+			var a, b int
+			So(stmt.QueryRowContext(context.Background()).Scan(&a), ShouldBeNil)
+			So(stmt.QueryRowContext(context.Background()).Scan(&b), ShouldBeNil)
+			So(a, ShouldEqual, b)
+		})
+
+		Convey("bind arguments", func() {
+			res, err := db.ExecContext(context.Background(), "insert into fields_inclusive (table_id) values (?)", 4242)
+			So(err, ShouldBeNil)
+			id, err := res.LastInsertId()
+			So(err, ShouldBeNil)
+			var tableID int
+			So(db.QueryRowContext(context.Background(), "select table_id from fields_inclusive where id = ?", id).Scan(&tableID), ShouldBeNil)
+			So(tableID, ShouldEqual, 4242)
+		})
+	})
+}
+
+func TestSqlColumnTypes(t *testing.T) {
+	Convey("column types", t, func() {
+		setupDB()
+		db, err := sql.Open("univedo", testPerspectiveURL)
+		So(err, ShouldBeNil)
+		defer db.Close()
+		rows, err := db.Query("select id, dummy_int8, dummy_double, dummy_char, dummy_text, dummy_blob, dummy_datetime, dummy_uuid, dummy_bool from dummy")
+		So(err, ShouldBeNil)
+		defer rows.Close()
+		types, err := rows.ColumnTypes()
+		So(err, ShouldBeNil)
+		So(types, ShouldHaveLength, 9)
+
+		Convey("have database type names", func() {
+			names := make([]string, len(types))
+			for i, t := range types {
+				names[i] = t.DatabaseTypeName()
+			}
+			So(names, ShouldResemble, []string{"ID", "INTEGER", "FLOAT", "CHAR", "TEXT", "BLOB", "DATETIME", "UUID", "BOOL"})
+		})
+
+		Convey("have scan types", func() {
+			So(types[0].ScanType(), ShouldEqual, reflect.TypeOf(uint64(0)))
+			So(types[1].ScanType(), ShouldEqual, reflect.TypeOf(int8(0)))
+			So(types[2].ScanType(), ShouldEqual, reflect.TypeOf(float64(0)))
+			So(types[3].ScanType(), ShouldEqual, reflect.TypeOf(""))
+			So(types[5].ScanType(), ShouldEqual, reflect.TypeOf([]byte(nil)))
+			So(types[6].ScanType(), ShouldEqual, reflect.TypeOf(time.Time{}))
+			So(types[7].ScanType(), ShouldEqual, reflect.TypeOf(""))
+			So(types[8].ScanType(), ShouldEqual, reflect.TypeOf(false))
+		})
+
+		Convey("have lengths", func() {
+			length, ok := types[3].Length()
+			So(ok, ShouldBeTrue)
+			So(length, ShouldEqual, 10)
+			length, ok = types[4].Length()
+			So(ok, ShouldBeTrue)
+			So(length, ShouldEqual, math.MaxInt64)
+			_, ok = types[1].Length()
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("know ids are not nullable", func() {
+			nullable, ok := types[0].Nullable()
+			So(ok, ShouldBeTrue)
+			So(nullable, ShouldBeFalse)
+			_, ok = types[1].Nullable()
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,198 @@
+package univedo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTraceArgs is the maximum length of the argument summary of a traced message
+const maxTraceArgs = 256
+
+// Redacted replaces values removed from traced messages by a Redactor
+const Redacted = "[redacted]"
+
+// A Redactor returns the arguments of a call or notification as they should be traced
+// It must not modify args in place but return a copy with sensitive values replaced.
+type Redactor func(method string, args []interface{}) []interface{}
+
+// RedactCredentials is a Redactor hiding the credentials passed to getSession
+// It is always applied to traced messages.
+func RedactCredentials(method string, args []interface{}) []interface{} {
+	if method != "getSession" || len(args) < 2 {
+		return args
+	}
+	redacted := append([]interface{}{}, args...)
+	redacted[1] = Redacted
+	return redacted
+}
+
+// WithLogger traces every message sent and received at debug level on logger
+func WithLogger(logger *slog.Logger) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.logger = logger
+	}
+}
+
+// WithRedactor adds a Redactor applied to the arguments of traced messages
+func WithRedactor(r Redactor) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.redactors = append(cfg.redactors, r)
+	}
+}
+
+// A traced call waiting for its answer
+type traceKey struct {
+	roID     uint64
+	callID   uint64
+	incoming bool
+}
+
+type traceCall struct {
+	method string
+	start  time.Time
+}
+
+// tracer logs the messages of a connection
+type tracer struct {
+	logger    *slog.Logger
+	redactors []Redactor
+
+	mutex sync.Mutex
+	calls map[traceKey]traceCall
+}
+
+func newTracer(logger *slog.Logger, redactors []Redactor) *tracer {
+	if logger == nil {
+		return nil
+	}
+	return &tracer{
+		logger:    logger,
+		redactors: append([]Redactor{RedactCredentials}, redactors...),
+		calls:     make(map[traceKey]traceCall),
+	}
+}
+
+// opcodeName returns the name of a remote object opcode
+func opcodeName(op interface{}) string {
+	switch op {
+	case romCall:
+		return "call"
+	case uint64(romAnswer):
+		return "answer"
+	case uint64(romNotify):
+		return "notify"
+	case uint64(romDelete):
+		return "delete"
+	}
+	return fmt.Sprint(op)
+}
+
+// trace logs the decoded message data sent (incoming false) or received on a remote object
+func (t *tracer) trace(incoming bool, roID uint64, data []interface{}) {
+	if t == nil || !t.logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+
+	msg := "univedo send"
+	if incoming {
+		msg = "univedo receive"
+	}
+	attrs := []slog.Attr{slog.Uint64("ro", roID)}
+	if len(data) > 0 {
+		attrs = append(attrs, slog.String("op", opcodeName(data[0])))
+	}
+
+	switch {
+	case len(data) >= 4 && data[0] == romCall:
+		callID, _ := data[1].(uint64)
+		method, _ := data[2].(string)
+		args, _ := data[3].([]interface{})
+		t.mutex.Lock()
+		t.calls[traceKey{roID, callID, incoming}] = traceCall{method: method, start: time.Now()}
+		t.mutex.Unlock()
+		attrs = append(attrs,
+			slog.Uint64("call", callID),
+			slog.String("method", method),
+			slog.String("args", summarize(t.redact(method, args))))
+
+	case len(data) >= 4 && data[0] == uint64(romAnswer):
+		callID, _ := data[1].(uint64)
+		// An answer received belongs to a call sent and vice versa
+		key := traceKey{roID, callID, !incoming}
+		t.mutex.Lock()
+		call, ok := t.calls[key]
+		delete(t.calls, key)
+		t.mutex.Unlock()
+		attrs = append(attrs, slog.Uint64("call", callID))
+		if ok {
+			attrs = append(attrs,
+				slog.String("method", call.method),
+				slog.Duration("duration", time.Since(call.start)))
+		}
+		attrs = append(attrs,
+			slog.Any("status", data[2]),
+			slog.String("result", summarize(data[3])))
+
+	case len(data) >= 3 && data[0] == uint64(romNotify):
+		name, _ := data[1].(string)
+		args, _ := data[2].([]interface{})
+		attrs = append(attrs,
+			slog.String("notification", name),
+			slog.String("args", summarize(t.redact(name, args))))
+	}
+
+	t.logger.LogAttrs(context.Background(), slog.LevelDebug, msg, attrs...)
+}
+
+// redact applies all redactors to the arguments of method
+func (t *tracer) redact(method string, args []interface{}) []interface{} {
+	for _, r := range t.redactors {
+		args = r(method, args)
+	}
+	return args
+}
+
+// summarize formats v for tracing, truncating long values
+func summarize(v interface{}) string {
+	var b strings.Builder
+	writeSummary(&b, v)
+	s := b.String()
+	if len(s) > maxTraceArgs {
+		s = s[:maxTraceArgs] + "..."
+	}
+	return s
+}
+
+func writeSummary(b *strings.Builder, v interface{}) {
+	if b.Len() > maxTraceArgs {
+		return
+	}
+	switch v := v.(type) {
+	case []interface{}:
+		b.WriteString("[")
+		for i, e := range v {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeSummary(b, e)
+		}
+		b.WriteString("]")
+	case string:
+		fmt.Fprintf(b, "%q", v)
+	case []byte:
+		fmt.Fprintf(b, "<%d bytes>", len(v))
+	case RemoteObject:
+		fmt.Fprintf(b, "%s(%d)", v.Name(), v.ID())
+	case interface {
+		remoteObjectReference() (string, uint64)
+	}:
+		name, id := v.remoteObjectReference()
+		fmt.Fprintf(b, "%s(%d)", name, id)
+	default:
+		fmt.Fprint(b, v)
+	}
+}
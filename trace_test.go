@@ -0,0 +1,87 @@
+package univedo
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+func TestTrace(t *testing.T) {
+	Convey("tracing", t, func() {
+		var out syncBuffer
+		logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		redactPing := func(method string, args []interface{}) []interface{} {
+			if method == "ping" {
+				return []interface{}{Redacted}
+			}
+			return args
+		}
+
+		client, server := Pipe()
+		go serveTestPeer(server, func(roID uint64, method string, args []interface{}) interface{} {
+			switch method {
+			case "getSession":
+				return testRORef{"com.univedo.session", 1}
+			case "ping":
+				return args[0]
+			}
+			return nil
+		})
+		connection := NewConnection(client, WithLogger(logger), WithRedactor(redactPing))
+		defer connection.Close()
+
+		session, err := connection.GetSession("bucket", map[string]interface{}{"password": "secret"})
+		So(err, ShouldBeNil)
+		_, err = session.Ping("hello")
+		So(err, ShouldBeNil)
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		So(len(lines), ShouldEqual, 4)
+
+		Convey("logs calls", func() {
+			So(lines[0], ShouldContainSubstring, `msg="univedo send" ro=0 op=call call=0 method=getSession`)
+		})
+
+		Convey("logs answers with method and duration", func() {
+			So(lines[1], ShouldContainSubstring, `msg="univedo receive" ro=0 op=answer call=0 method=getSession duration=`)
+			So(lines[1], ShouldContainSubstring, `result=com.univedo.session(1)`)
+		})
+
+		Convey("redacts credentials", func() {
+			So(out.String(), ShouldNotContainSubstring, "secret")
+			So(lines[0], ShouldContainSubstring, Redacted)
+		})
+
+		Convey("applies custom redactors", func() {
+			So(lines[2], ShouldContainSubstring, "method=ping")
+			So(lines[2], ShouldNotContainSubstring, "hello")
+		})
+	})
+
+	Convey("summarize truncates long values", t, func() {
+		s := summarize([]interface{}{strings.Repeat("x", 1000)})
+		So(len(s), ShouldEqual, maxTraceArgs+3)
+	})
+}
@@ -0,0 +1,246 @@
+package univedo
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go.net/websocket"
+)
+
+// A Transport carries protocol frames between a Connection and a server
+// SendFrame may be called concurrently with ReceiveFrame.
+type Transport interface {
+	SendFrame(frame []byte) error
+	ReceiveFrame() ([]byte, error)
+	Close() error
+}
+
+// websocketTransport sends every frame as a binary websocket message
+type websocketTransport struct {
+	ws *websocket.Conn
+}
+
+// NewWebsocketTransport returns a transport on an established websocket
+func NewWebsocketTransport(ws *websocket.Conn) Transport {
+	return &websocketTransport{ws: ws}
+}
+
+// websocketDialer returns a function dialing websocket transports to the server at rawURL
+func websocketDialer(rawURL string, cfg *dialConfig) (func() (Transport, error), error) {
+	// Extract the origin from the URL
+	origin := cfg.origin
+	if origin == "" {
+		o, err := originForURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		origin = o
+	}
+
+	// Append the protocol version
+	if !strings.HasSuffix(rawURL, "/") {
+		rawURL += "/"
+	}
+	rawURL += cfg.protocolPath
+
+	config, err := websocket.NewConfig(rawURL, origin)
+	if err != nil {
+		return nil, err
+	}
+	if config.Location.Scheme != "ws" && config.Location.Scheme != "wss" {
+		return nil, &url.Error{Op: "dial", URL: rawURL, Err: errors.New("unsupported scheme " + config.Location.Scheme)}
+	}
+	for k, v := range cfg.header {
+		config.Header[k] = v
+	}
+
+	return func() (Transport, error) {
+		ws, err := dialWebsocket(config, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return NewWebsocketTransport(ws), nil
+	}, nil
+}
+
+// dialWebsocket connects to the server and performs the TLS and websocket handshakes
+func dialWebsocket(config *websocket.Config, cfg *dialConfig) (*websocket.Conn, error) {
+	dialer := &net.Dialer{}
+	if cfg.dialer != nil {
+		*dialer = *cfg.dialer
+	}
+	if cfg.dialTimeout > 0 {
+		dialer.Timeout = cfg.dialTimeout
+	}
+
+	u := config.Location
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	conn, err := dialer.Dial("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.handshakeTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(cfg.handshakeTimeout))
+	}
+
+	if u.Scheme == "wss" {
+		tlsConfig := &tls.Config{}
+		if cfg.tlsConfig != nil {
+			tlsConfig = cfg.tlsConfig.Clone()
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ws, nil
+}
+
+func (t *websocketTransport) SendFrame(frame []byte) error {
+	return websocket.Message.Send(t.ws, frame)
+}
+
+func (t *websocketTransport) ReceiveFrame() ([]byte, error) {
+	var frame []byte
+	err := websocket.Message.Receive(t.ws, &frame)
+	return frame, err
+}
+
+func (t *websocketTransport) Close() error {
+	return t.ws.Close()
+}
+
+// pipeTransport is one end of an in-memory transport
+type pipeTransport struct {
+	in        <-chan []byte
+	out       chan<- []byte
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+// Pipe returns two connected in-memory transports
+// Frames sent on one end are received on the other, closing either end closes both.
+func Pipe() (Transport, Transport) {
+	a := make(chan []byte)
+	b := make(chan []byte)
+	closed := make(chan struct{})
+	closeOnce := new(sync.Once)
+	return &pipeTransport{in: a, out: b, closed: closed, closeOnce: closeOnce},
+		&pipeTransport{in: b, out: a, closed: closed, closeOnce: closeOnce}
+}
+
+func (t *pipeTransport) SendFrame(frame []byte) error {
+	f := make([]byte, len(frame))
+	copy(f, frame)
+	select {
+	case <-t.closed:
+		return io.ErrClosedPipe
+	default:
+	}
+	select {
+	case t.out <- f:
+		return nil
+	case <-t.closed:
+		return io.ErrClosedPipe
+	}
+}
+
+func (t *pipeTransport) ReceiveFrame() ([]byte, error) {
+	select {
+	case f := <-t.in:
+		return f, nil
+	case <-t.closed:
+		return nil, io.EOF
+	}
+}
+
+func (t *pipeTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+	})
+	return nil
+}
+
+// maxStreamFrameSize limits the size of frames accepted on stream transports
+const maxStreamFrameSize = 64 << 20
+
+// streamTransport prefixes every frame with its length as a big endian uint32
+type streamTransport struct {
+	rwc        io.ReadWriteCloser
+	writeMutex sync.Mutex
+}
+
+// NewStreamTransport returns a transport sending length-prefixed frames over a byte stream
+func NewStreamTransport(rwc io.ReadWriteCloser) Transport {
+	return &streamTransport{rwc: rwc}
+}
+
+// DialStream connects to a server speaking length-prefixed frames, e.g. on "tcp" or "unix" networks
+func DialStream(network, address string) (Transport, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewStreamTransport(conn), nil
+}
+
+func (t *streamTransport) SendFrame(frame []byte) error {
+	if len(frame) > maxStreamFrameSize {
+		return errors.New("frame too large for stream transport")
+	}
+	buf := make([]byte, 4+len(frame))
+	binary.BigEndian.PutUint32(buf, uint32(len(frame)))
+	copy(buf[4:], frame)
+
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+	_, err := t.rwc.Write(buf)
+	return err
+}
+
+func (t *streamTransport) ReceiveFrame() ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(t.rwc, header[:]); err != nil {
+		return nil, err
+	}
+	l := binary.BigEndian.Uint32(header[:])
+	if l > maxStreamFrameSize {
+		return nil, errors.New("frame too large for stream transport")
+	}
+	frame := make([]byte, l)
+	if _, err := io.ReadFull(t.rwc, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (t *streamTransport) Close() error {
+	return t.rwc.Close()
+}
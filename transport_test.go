@@ -0,0 +1,111 @@
+package univedo
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testRORef is sent by test peers to hand out remote objects
+type testRORef struct {
+	name string
+	id   uint64
+}
+
+func (r testRORef) remoteObjectReference() (string, uint64) {
+	return r.name, r.id
+}
+
+// noAnswer makes serveTestPeer drop a call without answering it
+var noAnswer = &struct{}{}
+
+// serveTestPeer answers every call received on t with the result of answer
+func serveTestPeer(t Transport, answer func(roID uint64, method string, args []interface{}) interface{}) {
+	for {
+		frame, err := t.ReceiveFrame()
+		if err != nil {
+			return
+		}
+		msg := &message{buffer: bytes.NewBuffer(frame)}
+		var data []interface{}
+		for !msg.empty() {
+			v, err := msg.read()
+			if err != nil {
+				return
+			}
+			data = append(data, v)
+		}
+		if data[1] != uint64(romCall) {
+			continue
+		}
+		roID := data[0].(uint64)
+		result := answer(roID, data[3].(string), data[4].([]interface{}))
+		if result == noAnswer {
+			continue
+		}
+		reply := &message{buffer: &bytes.Buffer{}}
+		for _, v := range []interface{}{roID, uint64(romAnswer), data[2], uint64(0), result} {
+			reply.send(v)
+		}
+		if t.SendFrame(reply.buffer.Bytes()) != nil {
+			return
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	Convey("transports", t, func() {
+		Convey("pipe frames in memory", func() {
+			a, b := Pipe()
+			go a.SendFrame([]byte("foo"))
+			f, err := b.ReceiveFrame()
+			So(err, ShouldBeNil)
+			So(f, ShouldResemble, []byte("foo"))
+			a.Close()
+			_, err = b.ReceiveFrame()
+			So(err, ShouldNotBeNil)
+			So(b.SendFrame([]byte("bar")), ShouldNotBeNil)
+		})
+
+		Convey("prefix frames with their length on streams", func() {
+			c1, c2 := net.Pipe()
+			a := NewStreamTransport(c1)
+			b := NewStreamTransport(c2)
+			go func() {
+				a.SendFrame([]byte("foo"))
+				a.SendFrame([]byte{})
+			}()
+			f, err := b.ReceiveFrame()
+			So(err, ShouldBeNil)
+			So(f, ShouldResemble, []byte("foo"))
+			f, err = b.ReceiveFrame()
+			So(err, ShouldBeNil)
+			So(f, ShouldResemble, []byte{})
+			a.Close()
+			b.Close()
+		})
+
+		Convey("run connections without a server", func() {
+			client, server := Pipe()
+			go serveTestPeer(server, func(roID uint64, method string, args []interface{}) interface{} {
+				switch method {
+				case "getSession":
+					return testRORef{"com.univedo.session", 1}
+				case "ping":
+					return args[0]
+				}
+				return nil
+			})
+			connection := NewConnection(client)
+			session, err := connection.GetSession("bucket", map[string]interface{}{"username": "marvin"})
+			So(err, ShouldBeNil)
+			So(session, ShouldNotBeNil)
+			pong, err := session.Ping("foo")
+			So(err, ShouldBeNil)
+			So(pong, ShouldEqual, "foo")
+			connection.Close()
+		})
+	})
+}
@@ -0,0 +1,291 @@
+package univedotest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	majorUInt       = 0
+	majorInt        = 1
+	majorByteString = 2
+	majorTextString = 3
+	majorArray      = 4
+	majorMap        = 5
+	majorTag        = 6
+	majorSimple     = 7
+)
+
+const (
+	tagDateTime     = 0
+	tagRemoteObject = 27
+	tagUuid         = 37
+	tagRecord       = 39
+)
+
+const (
+	simpleFalse   = 20
+	simpleTrue    = 21
+	simpleNull    = 22
+	simpleFloat32 = 26
+	simpleFloat64 = 27
+)
+
+// remoteObject references an object on the server or the client by name and id
+type remoteObject struct {
+	name string
+	id   uint64
+}
+
+// decoder reads the CBOR values of a frame
+type decoder struct {
+	buffer *bytes.Buffer
+}
+
+func (d *decoder) empty() bool {
+	return d.buffer.Len() == 0
+}
+
+func (d *decoder) readLen(typeByte byte) (uint64, error) {
+	switch l := typeByte & 0x1F; l {
+	case 24:
+		b, err := d.buffer.ReadByte()
+		return uint64(b), err
+	case 25, 26, 27:
+		n := 1 << (l - 24)
+		b := d.buffer.Next(n)
+		if len(b) != n {
+			return 0, errors.New("unexpected end of frame")
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, nil
+	default:
+		if l > 27 {
+			return 0, errors.New("invalid length")
+		}
+		return uint64(l), nil
+	}
+}
+
+func (d *decoder) read() (interface{}, error) {
+	typeByte, err := d.buffer.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	if typeByte>>5 == majorSimple {
+		switch typeByte & 0x1F {
+		case simpleFalse:
+			return false, nil
+		case simpleTrue:
+			return true, nil
+		case simpleNull:
+			return nil, nil
+		case simpleFloat32:
+			b := d.buffer.Next(4)
+			if len(b) != 4 {
+				return nil, errors.New("unexpected end of frame")
+			}
+			return math.Float32frombits(binary.BigEndian.Uint32(b)), nil
+		case simpleFloat64:
+			b := d.buffer.Next(8)
+			if len(b) != 8 {
+				return nil, errors.New("unexpected end of frame")
+			}
+			return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+		default:
+			return nil, errors.New("invalid simple value")
+		}
+	}
+
+	l, err := d.readLen(typeByte)
+	if err != nil {
+		return nil, err
+	}
+
+	switch typeByte >> 5 {
+	case majorUInt:
+		return l, nil
+
+	case majorInt:
+		return -int64(l) - 1, nil
+
+	case majorByteString, majorTextString:
+		b := d.buffer.Next(int(l))
+		if uint64(len(b)) != l {
+			return nil, errors.New("unexpected end of frame")
+		}
+		if typeByte>>5 == majorTextString {
+			return string(b), nil
+		}
+		return append([]byte{}, b...), nil
+
+	case majorArray:
+		arr := make([]interface{}, l)
+		for i := range arr {
+			if arr[i], err = d.read(); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+
+	case majorMap:
+		m := make(map[string]interface{}, l)
+		for i := uint64(0); i < l; i++ {
+			k, err := d.read()
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, errors.New("map keys must be strings")
+			}
+			if m[key], err = d.read(); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+
+	case majorTag:
+		v, err := d.read()
+		if err != nil {
+			return nil, err
+		}
+		switch l {
+		case tagDateTime:
+			s, ok := v.(string)
+			if !ok {
+				return nil, errors.New("datetime must be a string")
+			}
+			return time.Parse(time.RFC3339Nano, s)
+		case tagUuid:
+			b, ok := v.([]byte)
+			if !ok || len(b) != 16 {
+				return nil, errors.New("uuid must be 16 bytes")
+			}
+			return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+		case tagRecord:
+			return v, nil
+		case tagRemoteObject:
+			list, ok := v.([]interface{})
+			if !ok || len(list) != 2 {
+				return nil, errors.New("remote object must be a list of name and id")
+			}
+			name, _ := list[0].(string)
+			id, ok := list[1].(uint64)
+			if !ok {
+				return nil, errors.New("remote object id must be an uint")
+			}
+			return remoteObject{name: name, id: id}, nil
+		default:
+			return nil, errors.New("invalid tag")
+		}
+	}
+	return nil, errors.New("invalid major type")
+}
+
+// encoder writes CBOR values into a frame
+type encoder struct {
+	buffer bytes.Buffer
+}
+
+func (e *encoder) writeLen(major byte, l uint64) {
+	major <<= 5
+	switch {
+	case l <= 23:
+		e.buffer.WriteByte(major | byte(l))
+	case l < 0x100:
+		e.buffer.WriteByte(major | 24)
+		e.buffer.WriteByte(byte(l))
+	case l < 0x10000:
+		e.buffer.WriteByte(major | 25)
+		binary.Write(&e.buffer, binary.BigEndian, uint16(l))
+	case l < 0x100000000:
+		e.buffer.WriteByte(major | 26)
+		binary.Write(&e.buffer, binary.BigEndian, uint32(l))
+	default:
+		e.buffer.WriteByte(major | 27)
+		binary.Write(&e.buffer, binary.BigEndian, l)
+	}
+}
+
+func (e *encoder) writeInt(i int64) {
+	if i >= 0 {
+		e.writeLen(majorUInt, uint64(i))
+	} else {
+		e.writeLen(majorInt, uint64(-i-1))
+	}
+}
+
+func (e *encoder) write(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		e.buffer.WriteByte(majorSimple<<5 | simpleNull)
+	case bool:
+		if v {
+			e.buffer.WriteByte(majorSimple<<5 | simpleTrue)
+		} else {
+			e.buffer.WriteByte(majorSimple<<5 | simpleFalse)
+		}
+	case uint64:
+		e.writeLen(majorUInt, v)
+	case uint32:
+		e.writeLen(majorUInt, uint64(v))
+	case uint:
+		e.writeLen(majorUInt, uint64(v))
+	case int64:
+		e.writeInt(v)
+	case int32:
+		e.writeInt(int64(v))
+	case int:
+		e.writeInt(int64(v))
+	case float32:
+		e.buffer.WriteByte(majorSimple<<5 | simpleFloat32)
+		binary.Write(&e.buffer, binary.BigEndian, v)
+	case float64:
+		e.buffer.WriteByte(majorSimple<<5 | simpleFloat64)
+		binary.Write(&e.buffer, binary.BigEndian, v)
+	case string:
+		e.writeLen(majorTextString, uint64(len(v)))
+		e.buffer.WriteString(v)
+	case []byte:
+		e.writeLen(majorByteString, uint64(len(v)))
+		e.buffer.Write(v)
+	case []interface{}:
+		e.writeLen(majorArray, uint64(len(v)))
+		for _, x := range v {
+			if err := e.write(x); err != nil {
+				return err
+			}
+		}
+	case []string:
+		e.writeLen(majorArray, uint64(len(v)))
+		for _, x := range v {
+			e.write(x)
+		}
+	case map[string]interface{}:
+		e.writeLen(majorMap, uint64(len(v)))
+		for k, x := range v {
+			e.write(k)
+			if err := e.write(x); err != nil {
+				return err
+			}
+		}
+	case time.Time:
+		e.writeLen(majorTag, tagDateTime)
+		e.write(v.Format(time.RFC3339Nano))
+	case remoteObject:
+		e.writeLen(majorTag, tagRemoteObject)
+		e.write([]interface{}{v.name, v.id})
+	default:
+		return fmt.Errorf("cannot encode %T", v)
+	}
+	return nil
+}
@@ -0,0 +1,381 @@
+package univedotest
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"code.google.com/p/go.net/websocket"
+)
+
+const (
+	romCall   uint64 = 1
+	romAnswer uint64 = 2
+	romNotify uint64 = 3
+	romDelete uint64 = 4
+)
+
+// An object the client can call methods on
+type object interface {
+	call(c *conn, method string, args []interface{}) (interface{}, error)
+}
+
+// conn is the server side of a client connection
+type conn struct {
+	server  *Server
+	ws      *websocket.Conn
+	objects map[uint64]object
+	lastID  uint64
+
+	// results maps calls to the ids of the remote objects they returned, for pipelined calls
+	results map[callKey]uint64
+
+	// notifications are sent after the answer to the current call
+	notifications [][]interface{}
+}
+
+// callKey identifies a call by the remote object it was sent to and its call id
+type callKey struct {
+	roID, callID uint64
+}
+
+// serve handles a websocket connection until it is closed
+func (s *Server) serve(ws *websocket.Conn) {
+	defer ws.Close()
+	c := &conn{server: s, ws: ws, objects: map[uint64]object{0: &urologin{}}, results: map[callKey]uint64{}}
+	for {
+		var frame []byte
+		if err := websocket.Message.Receive(ws, &frame); err != nil {
+			return
+		}
+		if err := c.handle(frame); err != nil {
+			return
+		}
+	}
+}
+
+// register makes an object callable and returns the reference sent to the client
+func (c *conn) register(name string, o object) remoteObject {
+	c.lastID++
+	c.objects[c.lastID] = o
+	return remoteObject{name: name, id: c.lastID}
+}
+
+// notify queues a notification for the object with the given id
+func (c *conn) notify(id uint64, name string, args ...interface{}) {
+	c.notifications = append(c.notifications, []interface{}{id, romNotify, name, args})
+}
+
+func (c *conn) send(data ...interface{}) error {
+	e := &encoder{}
+	for _, v := range data {
+		if err := e.write(v); err != nil {
+			return err
+		}
+	}
+	return websocket.Message.Send(c.ws, e.buffer.Bytes())
+}
+
+func (c *conn) handle(frame []byte) error {
+	d := &decoder{buffer: bytes.NewBuffer(frame)}
+	var msg []interface{}
+	for !d.empty() {
+		v, err := d.read()
+		if err != nil {
+			return err
+		}
+		msg = append(msg, v)
+	}
+	if len(msg) < 2 {
+		return errors.New("message too short")
+	}
+	id, _ := msg[0].(uint64)
+	opcode, _ := msg[1].(uint64)
+
+	switch opcode {
+	case romCall:
+		if len(msg) != 5 && len(msg) != 6 {
+			return errors.New("invalid call")
+		}
+		callID, _ := msg[2].(uint64)
+		method, _ := msg[3].(string)
+		args, _ := msg[4].([]interface{})
+
+		var result interface{}
+		err := errors.New("unknown remote object")
+		o := c.objects[id]
+		if len(msg) == 6 {
+			// A pipelined call runs on the result of an earlier call, an assumed wire format
+			promised, _ := msg[5].(uint64)
+			o = nil
+			if target, ok := c.results[callKey{id, promised}]; ok {
+				o = c.objects[target]
+			} else {
+				err = errors.New("no remote object returned by the promised call")
+			}
+		}
+		if o != nil {
+			result, err = o.call(c, method, args)
+		}
+		if ref, ok := result.(remoteObject); ok && err == nil {
+			c.results[callKey{id, callID}] = ref.id
+		}
+		if err != nil {
+			c.notifications = nil
+			var payload interface{} = err.Error()
+			if ce, ok := err.(*codedError); ok {
+				payload = map[string]interface{}{"code": ce.code, "message": ce.message}
+			}
+			return c.send(id, romAnswer, callID, uint64(1), payload)
+		}
+		if err := c.send(id, romAnswer, callID, uint64(0), result); err != nil {
+			return err
+		}
+		notifications := c.notifications
+		c.notifications = nil
+		for _, n := range notifications {
+			if err := c.send(n...); err != nil {
+				return err
+			}
+		}
+
+	case romDelete:
+		if id != 0 {
+			delete(c.objects, id)
+			for key, roID := range c.results {
+				if key.roID == id || roID == id {
+					delete(c.results, key)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// codedError is sent to the client as a structured error with a code
+// The {code, message} answer is an assumed wire format.
+type codedError struct {
+	code    string
+	message string
+}
+
+func (e *codedError) Error() string {
+	return e.message
+}
+
+// checkArgs makes sure a call got the expected number of arguments
+func checkArgs(method string, args []interface{}, n int) error {
+	if len(args) != n {
+		return errors.New("wrong number of arguments for " + method)
+	}
+	return nil
+}
+
+// urologin is remote object 0 handing out sessions
+type urologin struct{}
+
+func (u *urologin) call(c *conn, method string, args []interface{}) (interface{}, error) {
+	switch method {
+	case "getSession":
+		if err := checkArgs(method, args, 2); err != nil {
+			return nil, err
+		}
+		bucket, ok := args[0].(string)
+		if !ok {
+			return nil, errors.New("bucket must be a string")
+		}
+		c.server.mutex.Lock()
+		epoch := c.server.epoch
+		c.server.mutex.Unlock()
+		return c.register("com.univedo.session", &session{bucket: bucket, epoch: epoch}), nil
+	case "negotiate":
+		// assumed wire format, see Server.negotiate
+		if err := checkArgs(method, args, 1); err != nil {
+			return nil, err
+		}
+		versions, ok := args[0].([]interface{})
+		if !ok {
+			return nil, errors.New("versions must be a list")
+		}
+		return c.server.negotiate(versions)
+	}
+	return nil, errors.New("unknown method " + method)
+}
+
+// session is a com.univedo.session on a bucket
+type session struct {
+	bucket string
+	// epoch is the server epoch the session was created in, see Server.ExpireSessions
+	epoch uint64
+}
+
+func (s *session) call(c *conn, method string, args []interface{}) (interface{}, error) {
+	if err := c.server.checkEpoch(s.epoch); err != nil {
+		return nil, err
+	}
+	switch method {
+	case "ping":
+		if err := checkArgs(method, args, 1); err != nil {
+			return nil, err
+		}
+		return args[0], nil
+
+	case "applyUts":
+		if err := checkArgs(method, args, 1); err != nil {
+			return nil, err
+		}
+		uts, ok := args[0].(string)
+		if !ok {
+			return nil, errors.New("uts must be a string")
+		}
+		return nil, c.server.applyUTS(s.bucket, uts)
+
+	case "getPerspective":
+		if err := checkArgs(method, args, 1); err != nil {
+			return nil, err
+		}
+		name, _ := args[0].(string)
+		c.server.mutex.Lock()
+		ok := c.server.bucket(s.bucket).perspectives[strings.ToLower(name)]
+		c.server.mutex.Unlock()
+		if !ok {
+			return nil, errors.New("unknown perspective " + name)
+		}
+		return c.register("com.univedo.perspective", &perspective{bucket: s.bucket, epoch: s.epoch}), nil
+	}
+	return nil, errors.New("unknown method " + method)
+}
+
+// perspective is a com.univedo.perspective of a bucket
+type perspective struct {
+	bucket string
+	epoch  uint64
+}
+
+func (p *perspective) call(c *conn, method string, args []interface{}) (interface{}, error) {
+	if err := c.server.checkEpoch(p.epoch); err != nil {
+		return nil, err
+	}
+	switch method {
+	case "query":
+		return c.register("com.univedo.query", &query{bucket: p.bucket}), nil
+	case "beginTransaction":
+		// assumed wire format, see beginTransaction
+		return p.beginTransaction(c, args)
+	}
+	return nil, errors.New("unknown method " + method)
+}
+
+// query is a com.univedo.query preparing statements in a bucket or transaction
+type query struct {
+	bucket string
+	tx     *transaction
+}
+
+func (q *query) call(c *conn, method string, args []interface{}) (interface{}, error) {
+	switch method {
+	case "prepare":
+		if err := checkArgs(method, args, 1); err != nil {
+			return nil, err
+		}
+		sql, ok := args[0].(string)
+		if !ok {
+			return nil, errors.New("query must be a string")
+		}
+		st, err := parse(sql)
+		if err != nil {
+			return nil, err
+		}
+
+		c.server.mutex.Lock()
+		var cols []column
+		tables, _, err := c.server.scope(q.bucket, q.tx)
+		if err == nil {
+			t := tables[strings.ToLower(st.table)]
+			if t == nil {
+				err = errors.New("unknown table " + st.table)
+			} else if err = st.check(t); err == nil {
+				cols, _ = st.resultColumns(t)
+			}
+		}
+		c.server.mutex.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		if q.tx != nil && q.tx.readOnly && st.kind != "select" {
+			return nil, errors.New("cannot " + st.kind + " in a read-only transaction")
+		}
+
+		ref := c.register("com.univedo.statement", &stmt{bucket: q.bucket, tx: q.tx, st: st})
+		names := make([]interface{}, len(cols))
+		types := make([]interface{}, len(cols))
+		for i, col := range cols {
+			names[i] = col.name
+			types[i] = map[string]interface{}{"type": col.typ, "length": uint64(col.length)}
+		}
+		c.notify(ref.id, "setColumnNames", names)
+		// the {type, length} maps of setColumnTypes are an assumed wire format
+		c.notify(ref.id, "setColumnTypes", types)
+		return ref, nil
+	}
+	return nil, errors.New("unknown method " + method)
+}
+
+// stmt is a com.univedo.statement
+type stmt struct {
+	bucket string
+	tx     *transaction
+	st     *statement
+}
+
+func (s *stmt) call(c *conn, method string, args []interface{}) (interface{}, error) {
+	switch method {
+	case "execute":
+		if err := checkArgs(method, args, 1); err != nil {
+			return nil, err
+		}
+		binds, _ := args[0].(map[string]interface{})
+
+		c.server.mutex.Lock()
+		var res *resultSet
+		tables, nextID, err := c.server.scope(s.bucket, s.tx)
+		if err == nil {
+			t := tables[strings.ToLower(s.st.table)]
+			if t == nil {
+				err = errors.New("unknown table " + s.st.table)
+			} else {
+				res, err = s.st.execute(t, binds, nextID)
+			}
+		}
+		if err == nil && s.tx == nil && s.st.kind != "select" {
+			c.server.bucket(s.bucket).version++
+		}
+		c.server.mutex.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		ref := c.register("com.univedo.result", &result{})
+		switch s.st.kind {
+		case "select":
+			for _, row := range res.rows {
+				c.notify(ref.id, "setTuple", row)
+			}
+			c.notify(ref.id, "setComplete")
+		case "insert":
+			c.notify(ref.id, "setId", res.insertedID)
+		default:
+			c.notify(ref.id, "setNAffectedRecords", res.affected)
+		}
+		return ref, nil
+	}
+	return nil, errors.New("unknown method " + method)
+}
+
+// result is a com.univedo.result, all its data is sent as notifications
+type result struct{}
+
+func (r *result) call(c *conn, method string, args []interface{}) (interface{}, error) {
+	return nil, errors.New("unknown method " + method)
+}
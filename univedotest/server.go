@@ -0,0 +1,188 @@
+// Package univedotest provides an in-process univedo server for hermetic tests
+//
+// The server speaks the CBOR remote object protocol over websockets and keeps
+// buckets in memory. It understands enough of UTS files to create the tables of
+// their perspectives and a small subset of SQL: selects with optional count(*),
+// inserts, updates and deletes, each with equality conditions joined by AND.
+// Transactions work on a snapshot of their bucket and fail to commit if the
+// bucket was written in the meantime.
+//
+// Parts of the protocol are not documented by univedo and only assumed here:
+// the {type, length} maps of setColumnTypes, beginTransaction with its options
+// and commit/rollback on the transaction, negotiate on urologin, errors answered
+// as {code, message} maps like credentials_expired, and pipelined calls carrying
+// the id of the promised call as sixth element. Tests relying on them show what
+// the client expects, not what a real server sends.
+package univedotest
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"code.google.com/p/go.net/websocket"
+)
+
+// Server is a fake univedo server
+type Server struct {
+	// URL of the server, e.g. ws://127.0.0.1:1234/, to be passed to univedo.Dial
+	URL string
+
+	httpServer *httptest.Server
+
+	// mutex guards the fields below
+	mutex        sync.Mutex
+	buckets      map[string]*bucket
+	versions     []string
+	capabilities []string
+	epoch        uint64
+}
+
+// A bucket holds the perspectives and tables created by applying UTS files
+type bucket struct {
+	perspectives map[string]bool
+	tables       map[string]*table
+	lastID       uint64
+	// version counts writes to detect conflicting transactions
+	version uint64
+}
+
+func (b *bucket) nextID() uint64 {
+	b.lastID++
+	return b.lastID
+}
+
+// NewServer starts a fake univedo server listening on a local port
+func NewServer() *Server {
+	s := &Server{buckets: make(map[string]*bucket), versions: []string{"v1"}}
+	s.httpServer = httptest.NewServer(websocket.Server{Handler: s.serve})
+	s.URL = "ws" + strings.TrimPrefix(s.httpServer.URL, "http") + "/"
+	return s
+}
+
+// Close shuts the server down
+func (s *Server) Close() {
+	s.httpServer.CloseClientConnections()
+	s.httpServer.Close()
+}
+
+// SetVersions sets the protocol versions the server negotiates, preferred first
+// Without versions the server does not support negotiation, like old servers.
+func (s *Server) SetVersions(versions ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.versions = versions
+}
+
+// SetCapabilities sets the features the server announces during negotiation
+func (s *Server) SetCapabilities(capabilities ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.capabilities = capabilities
+}
+
+// ExpireSessions makes the credentials of all existing sessions expire
+// Calls on the sessions and their perspectives then fail with the error code
+// "credentials_expired" until the client opens a new session. The error code is
+// an assumption, see the package documentation.
+func (s *Server) ExpireSessions() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.epoch++
+}
+
+// checkEpoch fails if sessions of the given epoch have expired
+func (s *Server) checkEpoch(epoch uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if epoch != s.epoch {
+		return &codedError{code: "credentials_expired", message: "credentials expired"}
+	}
+	return nil
+}
+
+// negotiate picks the preferred server version offered by the client
+// Without a common version the answer has no version. The method and its answer
+// are an assumption, see the package documentation.
+func (s *Server) negotiate(offered []interface{}) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.versions) == 0 {
+		return nil, errors.New("unknown method negotiate")
+	}
+	capabilities := make([]interface{}, len(s.capabilities))
+	for i, c := range s.capabilities {
+		capabilities[i] = c
+	}
+	for _, v := range s.versions {
+		for _, o := range offered {
+			if o == v {
+				return map[string]interface{}{"version": v, "capabilities": capabilities}, nil
+			}
+		}
+	}
+	return map[string]interface{}{"version": nil, "capabilities": capabilities}, nil
+}
+
+// bucket returns the bucket with the given name, creating it if necessary
+// The server mutex must be held.
+func (s *Server) bucket(name string) *bucket {
+	name = strings.ToLower(name)
+	b := s.buckets[name]
+	if b == nil {
+		b = &bucket{perspectives: make(map[string]bool), tables: make(map[string]*table)}
+		s.buckets[name] = b
+	}
+	return b
+}
+
+// Exec runs a SQL statement on a bucket, e.g. to insert test data
+// The bind parameters are given in the order of the placeholders.
+func (s *Server) Exec(bucketName, query string, args ...interface{}) error {
+	st, err := parse(query)
+	if err != nil {
+		return err
+	}
+	binds := make(map[string]interface{}, len(args))
+	for i, arg := range args {
+		binds[strconv.Itoa(i)] = arg
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b := s.bucket(bucketName)
+	t := b.tables[strings.ToLower(st.table)]
+	if t == nil {
+		return errors.New("unknown table " + st.table)
+	}
+	if err := st.check(t); err != nil {
+		return err
+	}
+	_, err = st.execute(t, binds, b.nextID)
+	b.version++
+	return err
+}
+
+// applyUTS creates the perspectives and tables of an UTS in a bucket, dropping existing data
+func (s *Server) applyUTS(bucketName, uts string) error {
+	perspectives, tables, err := parseUTS(uts)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b := s.bucket(bucketName)
+	for p := range perspectives {
+		b.perspectives[p] = true
+	}
+	for name, t := range tables {
+		for _, row := range t.rows {
+			row[0] = b.nextID()
+		}
+		b.tables[name] = t
+	}
+	b.version++
+	return nil
+}
@@ -0,0 +1,533 @@
+package univedotest
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A column of an in-memory table
+type column struct {
+	name   string
+	typ    string
+	length int
+}
+
+// An in-memory table, the first column is always the id
+type table struct {
+	name    string
+	columns []column
+	rows    [][]interface{}
+}
+
+func (t *table) columnIndex(name string) int {
+	for i, c := range t.columns {
+		if strings.EqualFold(c.name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// clone copies the table and its rows
+func (t *table) clone() *table {
+	c := &table{name: t.name, columns: t.columns, rows: make([][]interface{}, len(t.rows))}
+	for i, row := range t.rows {
+		c.rows[i] = append([]interface{}{}, row...)
+	}
+	return c
+}
+
+// An operand is either a literal value or a bind parameter
+type operand struct {
+	bind  int
+	value interface{}
+}
+
+func (o operand) eval(binds map[string]interface{}) interface{} {
+	if o.bind < 0 {
+		return o.value
+	}
+	return binds[strconv.Itoa(o.bind)]
+}
+
+// A condition compares a column with an operand
+type condition struct {
+	column string
+	value  operand
+}
+
+// A parsed SQL statement
+type statement struct {
+	kind    string
+	table   string
+	count   bool
+	columns []string
+	values  []operand
+	where   []condition
+}
+
+type token struct {
+	kind string
+	text string
+}
+
+// tokenize splits a query into identifiers, strings, numbers and symbols
+func tokenize(query string) ([]token, error) {
+	var tokens []token
+	r := []rune(query)
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c) || c == ';':
+			i++
+		case c == '\'':
+			var s []rune
+			i++
+			for {
+				if i >= len(r) {
+					return nil, errors.New("unterminated string in query")
+				}
+				if r[i] == '\'' {
+					if i+1 < len(r) && r[i+1] == '\'' {
+						s = append(s, '\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				s = append(s, r[i])
+				i++
+			}
+			tokens = append(tokens, token{"string", string(s)})
+		case unicode.IsDigit(c) || c == '-' && i+1 < len(r) && unicode.IsDigit(r[i+1]):
+			j := i + 1
+			for j < len(r) && (unicode.IsDigit(r[j]) || r[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{"number", string(r[i:j])})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i + 1
+			for j < len(r) && (unicode.IsLetter(r[j]) || unicode.IsDigit(r[j]) || r[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, token{"ident", string(r[i:j])})
+			i = j
+		case strings.ContainsRune("(),=*?", c):
+			tokens = append(tokens, token{"symbol", string(c)})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q in query", c)
+		}
+	}
+	return tokens, nil
+}
+
+// parser builds a statement from tokens
+type parser struct {
+	tokens []token
+	pos    int
+	binds  int
+}
+
+func (p *parser) peek() token {
+	if p.pos >= len(p.tokens) {
+		return token{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// accept consumes the next token if it is the given keyword or symbol
+func (p *parser) accept(text string) bool {
+	t := p.peek()
+	if t.kind != "string" && strings.EqualFold(t.text, text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return fmt.Errorf("expected %s in query", text)
+	}
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.next()
+	if t.kind != "ident" {
+		return "", fmt.Errorf("expected identifier in query, got %q", t.text)
+	}
+	return t.text, nil
+}
+
+func (p *parser) operand() (operand, error) {
+	t := p.next()
+	switch t.kind {
+	case "string":
+		return operand{bind: -1, value: t.text}, nil
+	case "number":
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return operand{bind: -1, value: i}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return operand{}, err
+		}
+		return operand{bind: -1, value: f}, nil
+	case "symbol":
+		if t.text == "?" {
+			p.binds++
+			return operand{bind: p.binds - 1}, nil
+		}
+	case "ident":
+		switch strings.ToLower(t.text) {
+		case "null":
+			return operand{bind: -1}, nil
+		case "true":
+			return operand{bind: -1, value: true}, nil
+		case "false":
+			return operand{bind: -1, value: false}, nil
+		}
+	}
+	return operand{}, fmt.Errorf("expected value in query, got %q", t.text)
+}
+
+func (p *parser) where() ([]condition, error) {
+	if !p.accept("where") {
+		return nil, nil
+	}
+	var conds []condition
+	for {
+		col, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		v, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, condition{column: col, value: v})
+		if !p.accept("and") {
+			return conds, nil
+		}
+	}
+}
+
+// parse parses the subset of SQL the fake server supports
+func parse(query string) (*statement, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	st := &statement{}
+
+	switch {
+	case p.accept("select"):
+		st.kind = "select"
+		switch {
+		case p.accept("count"):
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			if err := p.expect("*"); err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			st.count = true
+		case p.accept("*"):
+		default:
+			for {
+				col, err := p.ident()
+				if err != nil {
+					return nil, err
+				}
+				st.columns = append(st.columns, col)
+				if !p.accept(",") {
+					break
+				}
+			}
+		}
+		if err := p.expect("from"); err != nil {
+			return nil, err
+		}
+		if st.table, err = p.ident(); err != nil {
+			return nil, err
+		}
+		if st.where, err = p.where(); err != nil {
+			return nil, err
+		}
+
+	case p.accept("insert"):
+		st.kind = "insert"
+		if err := p.expect("into"); err != nil {
+			return nil, err
+		}
+		if st.table, err = p.ident(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		for {
+			col, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			st.columns = append(st.columns, col)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		if err := p.expect("values"); err != nil {
+			return nil, err
+		}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		for {
+			v, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			st.values = append(st.values, v)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		if len(st.columns) != len(st.values) {
+			return nil, errors.New("number of columns and values differ")
+		}
+
+	case p.accept("update"):
+		st.kind = "update"
+		if st.table, err = p.ident(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("set"); err != nil {
+			return nil, err
+		}
+		for {
+			col, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			v, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			st.columns = append(st.columns, col)
+			st.values = append(st.values, v)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if st.where, err = p.where(); err != nil {
+			return nil, err
+		}
+
+	case p.accept("delete"):
+		st.kind = "delete"
+		if err := p.expect("from"); err != nil {
+			return nil, err
+		}
+		if st.table, err = p.ident(); err != nil {
+			return nil, err
+		}
+		if st.where, err = p.where(); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported query %q", query)
+	}
+
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in query", p.peek().text)
+	}
+	return st, nil
+}
+
+// resultColumns returns the columns of the rows a statement returns
+func (st *statement) resultColumns(t *table) ([]column, error) {
+	if st.kind != "select" {
+		return nil, nil
+	}
+	if st.count {
+		return []column{{name: "COUNT(*)", typ: "integer", length: 8}}, nil
+	}
+	if st.columns == nil {
+		return t.columns, nil
+	}
+	cols := make([]column, len(st.columns))
+	for i, name := range st.columns {
+		idx := t.columnIndex(name)
+		if idx < 0 {
+			return nil, fmt.Errorf("unknown column %s", name)
+		}
+		cols[i] = t.columns[idx]
+		cols[i].name = name
+	}
+	return cols, nil
+}
+
+// check makes sure all columns a statement refers to exist
+func (st *statement) check(t *table) error {
+	if _, err := st.resultColumns(t); err != nil {
+		return err
+	}
+	names := []string{}
+	if st.kind != "select" {
+		names = append(names, st.columns...)
+	}
+	for _, c := range st.where {
+		names = append(names, c.column)
+	}
+	for _, name := range names {
+		if t.columnIndex(name) < 0 {
+			return fmt.Errorf("unknown column %s", name)
+		}
+	}
+	return nil
+}
+
+// A resultSet is the outcome of executing a statement
+type resultSet struct {
+	rows       [][]interface{}
+	insertedID uint64
+	affected   uint64
+}
+
+// matches reports whether a row satisfies all conditions
+func matches(t *table, row []interface{}, where []condition, binds map[string]interface{}) bool {
+	for _, c := range where {
+		if !equal(row[t.columnIndex(c.column)], c.value.eval(binds)) {
+			return false
+		}
+	}
+	return true
+}
+
+// execute runs a checked statement on a table, nextID allocates ids for inserts
+func (st *statement) execute(t *table, binds map[string]interface{}, nextID func() uint64) (*resultSet, error) {
+	res := &resultSet{}
+	switch st.kind {
+	case "select":
+		cols, _ := st.resultColumns(t)
+		var count int64
+		for _, row := range t.rows {
+			if !matches(t, row, st.where, binds) {
+				continue
+			}
+			count++
+			if st.count {
+				continue
+			}
+			out := make([]interface{}, len(cols))
+			for i, c := range cols {
+				out[i] = row[t.columnIndex(c.name)]
+			}
+			res.rows = append(res.rows, out)
+		}
+		if st.count {
+			res.rows = [][]interface{}{{count}}
+		}
+
+	case "insert":
+		row := make([]interface{}, len(t.columns))
+		res.insertedID = nextID()
+		row[0] = res.insertedID
+		for i, name := range st.columns {
+			if idx := t.columnIndex(name); idx > 0 {
+				row[idx] = st.values[i].eval(binds)
+			}
+		}
+		t.rows = append(t.rows, row)
+		res.affected = 1
+
+	case "update":
+		for _, row := range t.rows {
+			if !matches(t, row, st.where, binds) {
+				continue
+			}
+			for i, name := range st.columns {
+				if idx := t.columnIndex(name); idx > 0 {
+					row[idx] = st.values[i].eval(binds)
+				}
+			}
+			res.affected++
+		}
+
+	case "delete":
+		rows := t.rows[:0]
+		for _, row := range t.rows {
+			if matches(t, row, st.where, binds) {
+				res.affected++
+			} else {
+				rows = append(rows, row)
+			}
+		}
+		t.rows = rows
+	}
+	return res, nil
+}
+
+// equal compares values from the protocol, numbers of different types compare by value
+func equal(a, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := b.(time.Time)
+		return ok && ta.Equal(tb)
+	}
+	if sa, ok := a.(string); ok {
+		sb, ok := b.(string)
+		return ok && sa == sb
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case uint64:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,58 @@
+package univedotest
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSql(t *testing.T) {
+	Convey("fake sql", t, func() {
+		tbl := &table{name: "dummy", columns: []column{{name: "id", typ: "id"}, {name: "num", typ: "integer", length: 8}, {name: "txt", typ: "text"}}}
+		var lastID uint64
+		nextID := func() uint64 {
+			lastID++
+			return lastID
+		}
+		run := func(query string, binds map[string]interface{}) *resultSet {
+			st, err := parse(query)
+			So(err, ShouldBeNil)
+			So(st.check(tbl), ShouldBeNil)
+			res, err := st.execute(tbl, binds, nextID)
+			So(err, ShouldBeNil)
+			return res
+		}
+
+		Convey("inserts and selects", func() {
+			res := run("insert into dummy (num, txt) values (?, 'it''s')", map[string]interface{}{"0": int64(42)})
+			So(res.insertedID, ShouldEqual, 1)
+			res = run("SELECT txt, num FROM dummy WHERE id = ?", map[string]interface{}{"0": int64(1)})
+			So(res.rows, ShouldResemble, [][]interface{}{{"it's", int64(42)}})
+			res = run("select count(*) from dummy where num = 42", nil)
+			So(res.rows, ShouldResemble, [][]interface{}{{int64(1)}})
+		})
+
+		Convey("updates and deletes", func() {
+			run("insert into dummy (num) values (1)", nil)
+			run("insert into dummy (num) values (2)", nil)
+			res := run("update dummy set txt = ? where num = 2", map[string]interface{}{"0": "two"})
+			So(res.affected, ShouldEqual, 1)
+			res = run("delete from dummy where txt = 'two'", nil)
+			So(res.affected, ShouldEqual, 1)
+			res = run("select * from dummy", nil)
+			So(res.rows, ShouldResemble, [][]interface{}{{uint64(1), int64(1), nil}})
+		})
+
+		Convey("rejects invalid queries", func() {
+			_, err := parse("select from dummy")
+			So(err, ShouldNotBeNil)
+			_, err = parse("insert into dummy (num) values (1, 2)")
+			So(err, ShouldNotBeNil)
+			_, err = parse("drop table dummy")
+			So(err, ShouldNotBeNil)
+			st, err := parse("select foo from dummy")
+			So(err, ShouldBeNil)
+			So(st.check(tbl), ShouldNotBeNil)
+		})
+	})
+}
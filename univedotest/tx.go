@@ -0,0 +1,107 @@
+package univedotest
+
+import (
+	"errors"
+	"strings"
+)
+
+// transaction is a com.univedo.transaction working on a snapshot of a bucket
+// Its commit and rollback methods are an assumed wire format.
+// Commit replaces the tables of the bucket with the snapshot, unless the bucket
+// was written in the meantime, which makes transactions serializable.
+type transaction struct {
+	bucket   string
+	readOnly bool
+
+	// The fields below are guarded by the server mutex
+	tables   map[string]*table
+	lastID   uint64
+	version  uint64
+	finished bool
+}
+
+func (tx *transaction) nextID() uint64 {
+	tx.lastID++
+	return tx.lastID
+}
+
+// begin starts a transaction on a snapshot of a bucket
+func (s *Server) begin(bucketName string, readOnly bool) *transaction {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b := s.bucket(bucketName)
+	tx := &transaction{
+		bucket:   bucketName,
+		readOnly: readOnly,
+		tables:   make(map[string]*table, len(b.tables)),
+		lastID:   b.lastID,
+		version:  b.version,
+	}
+	for name, t := range b.tables {
+		tx.tables[name] = t.clone()
+	}
+	return tx
+}
+
+// scope returns the tables statements in a bucket or transaction work on and their id allocator
+// The server mutex must be held.
+func (s *Server) scope(bucketName string, tx *transaction) (map[string]*table, func() uint64, error) {
+	if tx == nil {
+		b := s.bucket(bucketName)
+		return b.tables, b.nextID, nil
+	}
+	if tx.finished {
+		return nil, nil, errors.New("transaction finished")
+	}
+	return tx.tables, tx.nextID, nil
+}
+
+func (tx *transaction) call(c *conn, method string, args []interface{}) (interface{}, error) {
+	switch method {
+	case "query":
+		return c.register("com.univedo.query", &query{bucket: tx.bucket, tx: tx}), nil
+
+	case "commit":
+		c.server.mutex.Lock()
+		defer c.server.mutex.Unlock()
+		if tx.finished {
+			return nil, errors.New("transaction finished")
+		}
+		tx.finished = true
+		if tx.readOnly {
+			return nil, nil
+		}
+		b := c.server.bucket(tx.bucket)
+		if b.version != tx.version {
+			return nil, errors.New("transaction conflicts with a concurrent write")
+		}
+		b.tables = tx.tables
+		b.lastID = tx.lastID
+		b.version++
+		return nil, nil
+
+	case "rollback":
+		c.server.mutex.Lock()
+		defer c.server.mutex.Unlock()
+		if tx.finished {
+			return nil, errors.New("transaction finished")
+		}
+		tx.finished = true
+		return nil, nil
+	}
+	return nil, errors.New("unknown method " + method)
+}
+
+// beginTransaction parses the options of a transaction and starts it
+// The method and its readOnly and isolation options are an assumed wire format.
+func (p *perspective) beginTransaction(c *conn, args []interface{}) (interface{}, error) {
+	if err := checkArgs("beginTransaction", args, 1); err != nil {
+		return nil, err
+	}
+	options, _ := args[0].(map[string]interface{})
+	readOnly, _ := options["readOnly"].(bool)
+	if isolation, ok := options["isolation"].(string); ok && !strings.EqualFold(isolation, "serializable") {
+		return nil, errors.New("unsupported isolation level " + isolation)
+	}
+	return c.register("com.univedo.transaction", c.server.begin(p.bucket, readOnly)), nil
+}
@@ -0,0 +1,112 @@
+package univedotest
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+)
+
+// The parts of an UTS file the fake server understands
+type utsFile struct {
+	Tables []utsTable `xml:"specification>tables>table"`
+	Apps   []utsApp   `xml:"apps>app"`
+}
+
+type utsApp struct {
+	UUID   string     `xml:"uuid,attr"`
+	Tables []utsTable `xml:"tables>table"`
+}
+
+type utsTable struct {
+	Name          string     `xml:"name,attr"`
+	UUID          string     `xml:"uuid,attr"`
+	Specification string     `xml:"specification,attr"`
+	Selective     string     `xml:"selective,attr"`
+	Fields        []utsField `xml:"fields>field"`
+}
+
+// fieldsTableName is the specification table the server describes all fields in
+const fieldsTableName = "Fields"
+
+type utsField struct {
+	Name          string `xml:"name,attr"`
+	UUID          string `xml:"uuid,attr"`
+	Specification string `xml:"specification,attr"`
+	Type          string `xml:"type,attr"`
+	Length        string `xml:"length,attr"`
+}
+
+// parseUTS returns the perspective uuids and the tables of their apps defined in an UTS
+// App tables inclusively showing the fields table of the specification get one row
+// per field of the UTS. The real server describes the fields of its system tables,
+// too, the fake server does not know them.
+func parseUTS(uts string) (map[string]bool, map[string]*table, error) {
+	var f utsFile
+	if err := xml.Unmarshal([]byte(uts), &f); err != nil {
+		return nil, nil, err
+	}
+
+	// Fields of the specification, apps refer to them by uuid
+	specFields := map[string]utsField{}
+	var fieldsTable string
+	var described []utsField
+	for _, t := range f.Tables {
+		if strings.EqualFold(t.Name, fieldsTableName) {
+			fieldsTable = strings.ToLower(t.UUID)
+		}
+		for _, field := range t.Fields {
+			specFields[strings.ToLower(field.UUID)] = field
+			described = append(described, field)
+		}
+	}
+	for _, app := range f.Apps {
+		for _, t := range app.Tables {
+			described = append(described, t.Fields...)
+		}
+	}
+
+	perspectives := map[string]bool{}
+	tables := map[string]*table{}
+	for _, app := range f.Apps {
+		perspectives[strings.ToLower(app.UUID)] = true
+		for _, t := range app.Tables {
+			tbl := &table{name: t.Name}
+			tbl.columns = append(tbl.columns, column{name: "id", typ: "id"})
+			for _, field := range t.Fields {
+				if strings.EqualFold(field.Name, "id") {
+					continue
+				}
+				if spec, ok := specFields[strings.ToLower(field.Specification)]; ok && field.Type == "" {
+					field.Type = spec.Type
+					field.Length = spec.Length
+				}
+				if field.Type == "" {
+					field.Type = "text"
+				}
+				length, _ := strconv.Atoi(field.Length)
+				tbl.columns = append(tbl.columns, column{name: field.Name, typ: field.Type, length: length})
+			}
+			if fieldsTable != "" && strings.ToLower(t.Specification) == fieldsTable && t.Selective != "true" {
+				tbl.describeFields(described)
+			}
+			tables[strings.ToLower(t.Name)] = tbl
+		}
+	}
+	return perspectives, tables, nil
+}
+
+// describeFields adds a row for every field, ids are set by the bucket
+func (t *table) describeFields(fields []utsField) {
+	for _, field := range fields {
+		row := make([]interface{}, len(t.columns))
+		for j, col := range t.columns {
+			switch strings.ToLower(col.name) {
+			case "name":
+				row[j] = field.Name
+			case "type":
+				row[j] = field.Type
+			}
+		}
+		t.rows = append(t.rows, row)
+	}
+}
@@ -0,0 +1,27 @@
+package univedotest
+
+import (
+	"io/ioutil"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUTS(t *testing.T) {
+	Convey("fake uts", t, func() {
+		uts, err := ioutil.ReadFile("../test.uts")
+		So(err, ShouldBeNil)
+		_, tables, err := parseUTS(string(uts))
+		So(err, ShouldBeNil)
+
+		Convey("describes fields in inclusive fields tables", func() {
+			// One row for each of the 63 fields of test.uts
+			So(len(tables["fields_inclusive"].rows), ShouldEqual, 63)
+			So(tables["fields_selective"].rows, ShouldBeEmpty)
+		})
+
+		Convey("leaves other tables empty", func() {
+			So(tables["dummy"].rows, ShouldBeEmpty)
+		})
+	})
+}
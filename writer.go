@@ -0,0 +1,126 @@
+package univedo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultSendQueue is the number of frames queued for sending by default
+const defaultSendQueue = 64
+
+// flushTimeout bounds how long Close waits for queued frames to be written
+const flushTimeout = 5 * time.Second
+
+// WithSendQueue sets how many outgoing frames may wait for the writer
+// Senders block, or fail when their context is done, while the queue is full.
+func WithSendQueue(size int) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.sendQueue = size
+	}
+}
+
+// A frame waiting to be written
+type writeRequest struct {
+	frame  []byte
+	result chan error
+}
+
+// frameWriter writes all frames of a transport from a single goroutine
+type frameWriter struct {
+	t     Transport
+	queue chan writeRequest
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func newFrameWriter(t Transport, size int) *frameWriter {
+	if size < 1 {
+		size = defaultSendQueue
+	}
+	w := &frameWriter{
+		t:     t,
+		queue: make(chan writeRequest, size),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *frameWriter) run() {
+	defer close(w.done)
+	for {
+		select {
+		case req := <-w.queue:
+			req.result <- w.t.SendFrame(req.frame)
+		case <-w.stop:
+			// Flush what was queued before stopping
+			for {
+				select {
+				case req := <-w.queue:
+					req.result <- w.t.SendFrame(req.frame)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write queues frame and returns the error writing it
+// ctx only bounds waiting for room in the queue, a queued frame is always written.
+func (w *frameWriter) write(ctx context.Context, frame []byte) error {
+	select {
+	case <-w.stop:
+		return ErrConnectionLost
+	default:
+	}
+
+	req := writeRequest{frame: frame, result: make(chan error, 1)}
+	select {
+	case w.queue <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-w.done:
+		return ErrConnectionLost
+	}
+
+	select {
+	case err := <-req.result:
+		return err
+	case <-w.done:
+		select {
+		case err := <-req.result:
+			return err
+		default:
+			return ErrConnectionLost
+		}
+	}
+}
+
+// close stops the writer after writing the queued frames, waiting at most timeout
+func (w *frameWriter) close(timeout time.Duration) {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+	select {
+	case <-w.done:
+	case <-time.After(timeout):
+	}
+}
+
+// contextSender is implemented by senders that can stop waiting to send when a context is done
+type contextSender interface {
+	sendMessageContext(context.Context, ...interface{}) error
+}
+
+// sendContext sends a message on s, giving up on queueing it when ctx is done
+func sendContext(ctx context.Context, s Sender, data ...interface{}) error {
+	if cs, ok := s.(contextSender); ok {
+		return cs.sendMessageContext(ctx, data...)
+	}
+	return s.sendMessage(data...)
+}
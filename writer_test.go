@@ -0,0 +1,117 @@
+package univedo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testWriteTransport records the frames sent and detects concurrent writes
+type testWriteTransport struct {
+	Transport
+	block      chan struct{}
+	err        error
+	writing    int32
+	concurrent int32
+
+	mutex  sync.Mutex
+	frames [][]byte
+}
+
+func (t *testWriteTransport) SendFrame(frame []byte) error {
+	if atomic.AddInt32(&t.writing, 1) > 1 {
+		atomic.StoreInt32(&t.concurrent, 1)
+	}
+	defer atomic.AddInt32(&t.writing, -1)
+	if t.block != nil {
+		<-t.block
+	}
+	time.Sleep(time.Millisecond)
+	t.mutex.Lock()
+	t.frames = append(t.frames, frame)
+	t.mutex.Unlock()
+	return t.err
+}
+
+func (t *testWriteTransport) count() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.frames)
+}
+
+func TestFrameWriter(t *testing.T) {
+	Convey("the frame writer", t, func() {
+		Convey("writes frames one at a time", func() {
+			tr := &testWriteTransport{}
+			w := newFrameWriter(tr, 4)
+			var wg sync.WaitGroup
+			var failed int32
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if w.write(context.Background(), []byte{1}) != nil {
+						atomic.AddInt32(&failed, 1)
+					}
+				}()
+			}
+			wg.Wait()
+			So(atomic.LoadInt32(&failed), ShouldEqual, 0)
+			So(tr.count(), ShouldEqual, 20)
+			So(atomic.LoadInt32(&tr.concurrent), ShouldEqual, 0)
+			w.close(time.Second)
+		})
+
+		Convey("reports write errors to the sender", func() {
+			writeErr := errors.New("broken pipe")
+			w := newFrameWriter(&testWriteTransport{err: writeErr}, 4)
+			So(w.write(context.Background(), []byte{1}), ShouldEqual, writeErr)
+			w.close(time.Second)
+		})
+
+		Convey("stops waiting for a full queue when the context is done", func() {
+			tr := &testWriteTransport{block: make(chan struct{})}
+			w := newFrameWriter(tr, 1)
+			// One frame is being written and one is queued
+			go w.write(context.Background(), []byte{1})
+			go w.write(context.Background(), []byte{2})
+			time.Sleep(10 * time.Millisecond)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			So(w.write(ctx, []byte{3}), ShouldEqual, context.DeadlineExceeded)
+
+			close(tr.block)
+			w.close(time.Second)
+			So(tr.count(), ShouldEqual, 2)
+		})
+
+		Convey("flushes queued frames on close", func() {
+			tr := &testWriteTransport{block: make(chan struct{})}
+			w := newFrameWriter(tr, 8)
+			for i := 0; i < 5; i++ {
+				go w.write(context.Background(), []byte{byte(i)})
+			}
+			time.Sleep(10 * time.Millisecond)
+			close(tr.block)
+			w.close(time.Second)
+			So(tr.count(), ShouldEqual, 5)
+			So(w.write(context.Background(), []byte{1}), ShouldEqual, ErrConnectionLost)
+		})
+	})
+
+	Convey("calls fail with the write error", t, func() {
+		client, server := Pipe()
+		writeErr := errors.New("broken pipe")
+		connection := NewConnection(&testWriteTransport{Transport: client, err: writeErr})
+		defer connection.Close()
+		defer server.Close()
+		_, err := connection.GetSession("bucket", nil)
+		So(err, ShouldEqual, writeErr)
+	})
+}